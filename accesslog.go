@@ -0,0 +1,199 @@
+package urlshort
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AccessLog is middleware that logs every request to the
+// handler it wraps with log/slog. Wrapped around Handler, each
+// record says which link matched and where it redirected to,
+// or that the fallback served the request:
+//
+//     log := &urlshort.AccessLog{Logger: slog.New(slog.NewJSONHandler(os.Stderr, nil))}
+//     mux.Handle("/", log.Wrap(urlshort.Handler(store, fallback)))
+//
+// Records have the message "request" and the attributes
+// request_id, method, host, path, rule (the key of the matched
+// link, or its Match expression for a rule), dest, status,
+// latency and fallback. Requests answered with a 5xx status
+// are logged at the error level and the rest at info.
+//
+// The request ID is taken from the X-Request-ID header, or
+// generated if the request has none, and is echoed in the
+// response's X-Request-ID header. Handlers further down can
+// get it with RequestID.
+type AccessLog struct {
+	// Logger receives the records. If nil, slog.Default()
+	// is used.
+	Logger *slog.Logger
+	// Sampling limits how many requests are logged for busy
+	// links. The zero value logs every request.
+	Sampling Sampling
+
+	mu      sync.Mutex
+	sampled map[string]*sampleWindow
+}
+
+// Sampling is the sampling policy of an AccessLog. Requests
+// are counted per matched link, and fallbacks all count
+// towards one bucket: in each Tick the first Initial requests
+// of a bucket are logged, and after that every Thereafter-th
+// one, or none if Thereafter is 0. Requests answered with a
+// 5xx status are always logged.
+type Sampling struct {
+	Initial    int
+	Thereafter int
+	// Tick is the length of a sampling window. If zero, it is
+	// one second.
+	Tick time.Duration
+}
+
+type sampleWindow struct {
+	start time.Time
+	n     int
+}
+
+// accessRecord is filled in by Handler for AccessLog.
+type accessRecord struct {
+	rule, dest string
+	fallback   bool
+}
+
+type accessKey struct{}
+type requestIDKey struct{}
+
+// RequestID returns the request ID that AccessLog assigned to
+// the request ctx belongs to, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Wrap returns next with its requests logged.
+func (l *AccessLog) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		rec := &accessRecord{}
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, accessKey{}, rec)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if sw.status >= 500 {
+			level = slog.LevelError
+		} else if !l.sample(rec) {
+			return
+		}
+		logger := l.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.LogAttrs(r.Context(), level, "request",
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("host", canonicalHost(r.Host)),
+			slog.String("path", r.URL.Path),
+			slog.String("rule", rec.rule),
+			slog.String("dest", rec.dest),
+			slog.Int("status", sw.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Bool("fallback", rec.fallback),
+		)
+	})
+}
+
+// sample reports whether the request described by rec should
+// be logged.
+func (l *AccessLog) sample(rec *accessRecord) bool {
+	s := l.Sampling
+	if s.Initial <= 0 && s.Thereafter <= 0 {
+		return true
+	}
+	tick := s.Tick
+	if tick <= 0 {
+		tick = time.Second
+	}
+	bucket := rec.rule
+	if rec.fallback {
+		bucket = "" // no link key is empty
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sampled == nil {
+		l.sampled = make(map[string]*sampleWindow)
+	}
+	now := time.Now()
+	win := l.sampled[bucket]
+	if win == nil || now.Sub(win.start) >= tick {
+		win = &sampleWindow{start: now}
+		l.sampled[bucket] = win
+	}
+	win.n++
+	if win.n <= s.Initial {
+		return true
+	}
+	return s.Thereafter > 0 && (win.n-s.Initial)%s.Thereafter == 0
+}
+
+// recordMatch tells the AccessLog around r, if any, that link
+// matched and r was redirected to dest.
+func recordMatch(r *http.Request, link Link, dest string) {
+	if rec, ok := r.Context().Value(accessKey{}).(*accessRecord); ok {
+		rec.rule, rec.dest = link.Key(), dest
+		if link.Match != "" {
+			rec.rule = link.Host + link.Match
+		}
+	}
+}
+
+// recordFallback tells the AccessLog around r, if any, that
+// the fallback handler served r.
+func recordFallback(r *http.Request) {
+	if rec, ok := r.Context().Value(accessKey{}).(*accessRecord); ok {
+		rec.fallback = true
+	}
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// statusWriter remembers the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying
+// ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
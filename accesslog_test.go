@@ -0,0 +1,123 @@
+package urlshort
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	store := NewMapStore(map[string]string{"/a": "https://a.com"})
+	if err := store.Put(context.Background(), Link{Match: `^/docs/(.*)$`, URL: "https://docs.example.com/$1"}); err != nil {
+		t.Fatal(err)
+	}
+	failing := StoreFunc(func(ctx context.Context, key string) (Link, error) {
+		return Link{}, errors.New("backend down")
+	})
+
+	records := func() []map[string]interface{} {
+		var out []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var rec map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatalf("Invalid log line %q: %v", line, err)
+			}
+			out = append(out, rec)
+		}
+		buf.Reset()
+		return out
+	}
+
+	t.Run("it logs the matched link", func(t *testing.T) {
+		log := &AccessLog{Logger: logger}
+		handler := log.Wrap(Handler(store, http.HandlerFunc(fallback)))
+		request := httptest.NewRequest("GET", "http://go.corp/docs/x", nil)
+		request.Header.Set("X-Request-ID", "abc123")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, request)
+		if got := resp.Header().Get("X-Request-ID"); got != "abc123" {
+			t.Errorf("Expected the request ID to be echoed, got %q", got)
+		}
+
+		recs := records()
+		if len(recs) != 1 {
+			t.Fatalf("Expected 1 record, got %d", len(recs))
+		}
+		rec := recs[0]
+		for key, want := range map[string]interface{}{
+			"msg":        "request",
+			"request_id": "abc123",
+			"host":       "go.corp",
+			"path":       "/docs/x",
+			"rule":       `^/docs/(.*)$`,
+			"dest":       "https://docs.example.com/x",
+			"status":     float64(http.StatusFound),
+			"fallback":   false,
+		} {
+			if rec[key] != want {
+				t.Errorf("Expected %s to be %v, got %v", key, want, rec[key])
+			}
+		}
+		if _, ok := rec["latency"]; !ok {
+			t.Error("Expected a latency")
+		}
+	})
+
+	t.Run("it logs fallbacks", func(t *testing.T) {
+		log := &AccessLog{Logger: logger}
+		var id string
+		handler := log.Wrap(Handler(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id = RequestID(r.Context())
+			http.NotFound(w, r)
+		})))
+		serve(handler, "/nope")
+		rec := records()[0]
+		if rec["fallback"] != true || rec["status"] != float64(http.StatusNotFound) || rec["rule"] != "" {
+			t.Errorf("Unexpected record %v", rec)
+		}
+		if id == "" || rec["request_id"] != id {
+			t.Errorf("Expected the generated request ID %q in the record, got %v", id, rec["request_id"])
+		}
+	})
+
+	t.Run("it samples busy links", func(t *testing.T) {
+		log := &AccessLog{Logger: logger, Sampling: Sampling{Initial: 2, Thereafter: 3, Tick: time.Hour}}
+		handler := log.Wrap(Handler(store, http.HandlerFunc(fallback)))
+		for i := 0; i < 10; i++ {
+			serve(handler, "/a")
+		}
+		serve(handler, "/nope")
+		// The first 2, then the 5th and 8th.
+		recs := records()
+		if len(recs) != 5 {
+			t.Fatalf("Expected 4 records for /a and 1 for the fallback, got %d", len(recs))
+		}
+		if recs[4]["fallback"] != true {
+			t.Errorf("Expected the fallback to be sampled separately, got %v", recs[4])
+		}
+	})
+
+	t.Run("it always logs server errors", func(t *testing.T) {
+		log := &AccessLog{Logger: logger, Sampling: Sampling{Initial: 1, Tick: time.Hour}}
+		handler := log.Wrap(Handler(failing, http.HandlerFunc(fallback)))
+		for i := 0; i < 3; i++ {
+			serve(handler, "/a")
+		}
+		recs := records()
+		if len(recs) != 3 || recs[2]["level"] != "ERROR" {
+			t.Errorf("Expected 3 records at the error level, got %v", recs)
+		}
+	})
+}
@@ -0,0 +1,640 @@
+package urlshort
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Admin is an http.Handler serving a JSON API for managing
+// the links in a MutableStore at runtime:
+//
+//     GET    /api/links           list every link but tombstones
+//     POST   /api/links           create a link
+//     GET    /api/links/{path}    get the link for /{path} (410 if deleted)
+//     PUT    /api/links/{path}    create or replace it
+//     DELETE /api/links/{path}    delete it, leaving a tombstone
+//     POST   /api/shorten         create a link with a generated path
+//     GET    /api/clicks          total clicks of every link
+//     GET    /api/clicks/{path}   total and daily clicks of /{path}
+//     GET    /api/tombstones      list deleted links
+//     POST   /api/restore/{path}  restore the deleted link for /{path}
+//     POST   /api/tombstones/purge  permanently remove tombstones
+//     GET    /api/history/{path}    list the revisions of /{path}
+//     POST   /api/rollback/{path}   roll /{path} back to an earlier revision
+//     GET    /api/audit             query the audit log
+//
+// Host-scoped links are addressed by adding ?host=name to the
+// {path} routes. Match rules can't be created or deleted
+// through Admin; they belong in link files, or are removed
+// from other stores with urlshort rm -match. Links are validated with the same rules as
+// YAMLHandler, and also checked for redirect loops against
+// the links already in the store when it is a Lister.
+//
+// Creating a link that already exists, or a template that is
+// ambiguous with an existing one, fails with 409 Conflict;
+// reading or deleting one that doesn't exist fails with 404
+// Not Found. PUT is an upsert: it answers 201 Created if there
+// was no link for the path, or only a tombstone, and 200 OK if
+// it replaced one. Errors have a JSON body of the form
+//
+//     {"error": "invalid link", "problems": ["url scheme ..."]}
+//
+// The body for /api/shorten is {"url": "https://...", "host":
+// "optional"}. The response is the link plus its full short
+// URL, with status 201 if it was created or 200 if an existing
+// generated link already pointed at the URL. The new link is
+// validated like any other; if it is invalid, say because the
+// URL points back at the short link itself, the request fails
+// with 422 Unprocessable Entity.
+//
+// Deleting a link soft deletes it (see tombstone.go), with
+// the reason given by the reason parameter and the user
+// returned by the Actor field; ?purge=true removes it for good
+// instead. Creating a link where there is a tombstone replaces
+// the tombstone. Purging removes the tombstones older than the
+// older_than parameter, a duration such as 720h, or all of
+// them if it is missing.
+//
+// Every change is made on behalf of the user returned by the
+// Actor field (see ContextWithActor), which a RecordingStore
+// records as its author. The history routes need the History
+// field to be set, and otherwise fail with 501 Not
+// Implemented. Rolling back takes the version to go back to as
+// the version parameter, and answers with the restored link,
+// or 204 No Content if that version removed it.
+//
+// The audit route needs the Audit field to be set, and
+// otherwise fails with 501 Not Implemented. It returns the
+// events of the given actor, if any, between the since and
+// until parameters, RFC 3339 timestamps or dates, either of
+// which may be left out:
+//
+//     GET /api/audit?actor=alice&since=2025-05-01&until=2025-06-01
+//
+// The click routes need the Clicks field to be set, and
+// otherwise fail with 501 Not Implemented. Daily counts cover
+// the last 30 days, or as many as the days parameter says.
+//
+// Paths must be allowed by the AliasPolicy set with
+// WithAliasPolicy (DefaultAliasPolicy by default), which also
+// applies to generated paths.
+//
+// Mount it next to the redirect handler, for example:
+//
+//     mux.Handle("/api/", urlshort.NewAdmin(store))
+//     mux.Handle("/", urlshort.Handler(store, fallback))
+type Admin struct {
+	// Shortener generates the paths for /api/shorten. If nil,
+	// the zero Shortener is used.
+	Shortener *Shortener
+	// Clicks is where the click routes get their numbers;
+	// pass it to the redirect handler with WithClickSink.
+	Clicks *ClickCounter
+	// History is where the history routes find revisions. For
+	// changes to be recorded in it, the store must be, or
+	// wrap, a RecordingStore using it.
+	History History
+	// Audit is where the audit route finds events, and where
+	// purges are recorded. For changes to single links to be
+	// recorded in it, the store must be, or wrap, a
+	// RecordingStore using it.
+	Audit *AuditLog
+	// Actor returns the user making a request, which is
+	// recorded on the links they delete and as the author of
+	// their changes. Admin doesn't authenticate anyone, so
+	// Actor should come from whatever does. If nil, the user
+	// is "anonymous", or with TrustProxyUser the one named by
+	// the request's X-Forwarded-User header.
+	Actor func(r *http.Request) string
+	// TrustProxyUser makes Admin take the user from the
+	// X-Forwarded-User header. Only set it when Admin is behind
+	// an authenticating proxy that sets the header and strips
+	// it from client requests; otherwise anyone can claim to
+	// be anyone.
+	TrustProxyUser bool
+
+	store MutableStore
+	opts  *options
+	mux   *http.ServeMux
+	mu    sync.Mutex // serializes check-then-write sequences
+}
+
+// NewAdmin returns an Admin that manages the links in store.
+// Listing links needs store to also implement Lister. With
+// WithMetrics, Admin sets the links gauge of store after
+// every change, under the name set with WithSource or else
+// "admin"; use it for stores, such as a MapStore, that don't
+// set the gauge themselves.
+func NewAdmin(store MutableStore, opts ...Option) *Admin {
+	a := &Admin{store: store, opts: newOptions(opts), mux: http.NewServeMux()}
+	a.mux.HandleFunc("GET /api/links", a.list)
+	a.mux.HandleFunc("POST /api/links", a.create)
+	a.mux.HandleFunc("GET /api/links/{path...}", a.get)
+	a.mux.HandleFunc("PUT /api/links/{path...}", a.put)
+	a.mux.HandleFunc("DELETE /api/links/{path...}", a.delete)
+	a.mux.HandleFunc("POST /api/shorten", a.shorten)
+	a.mux.HandleFunc("GET /api/clicks", a.clickTotals)
+	a.mux.HandleFunc("GET /api/clicks/{path...}", a.linkClicks)
+	a.mux.HandleFunc("GET /api/tombstones", a.tombstones)
+	a.mux.HandleFunc("POST /api/tombstones/purge", a.purge)
+	a.mux.HandleFunc("POST /api/restore/{path...}", a.restore)
+	a.mux.HandleFunc("GET /api/history/{path...}", a.history)
+	a.mux.HandleFunc("POST /api/rollback/{path...}", a.rollback)
+	a.mux.HandleFunc("GET /api/audit", a.audit)
+	return a
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(ContextWithActor(r.Context(), a.actor(r)))
+	a.mux.ServeHTTP(w, r)
+	if lister, ok := a.store.(Lister); ok && r.Method != http.MethodGet {
+		a.opts.metrics.recount(r.Context(), a.opts.sourceName("admin"), lister)
+	}
+}
+
+func (a *Admin) list(w http.ResponseWriter, r *http.Request) {
+	lister, ok := a.store.(Lister)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, errors.New("store can't list links"))
+		return
+	}
+	all, err := lister.List(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	links := []Link{}
+	for _, link := range all {
+		if !link.Deleted() {
+			links = append(links, link)
+		}
+	}
+	writeJSON(w, http.StatusOK, links)
+}
+
+func (a *Admin) get(w http.ResponseWriter, r *http.Request) {
+	link, err := a.lookupExact(r.Context(), a.requestKey(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if link.Deleted() {
+		status = http.StatusGone
+	}
+	writeJSON(w, status, link)
+}
+
+func (a *Admin) create(w http.ResponseWriter, r *http.Request) {
+	link, ok := readLink(w, r)
+	if !ok {
+		return
+	}
+	link.Path = a.opts.aliases.fold(link.Path)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.validate(r.Context(), link); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if existing, err := a.lookupExact(r.Context(), link.Key()); err == nil && !existing.Deleted() {
+		writeStoreError(w, fmt.Errorf("%w: %s already exists", ErrConflict, link.Key()))
+		return
+	} else if err != nil && !errors.Is(err, ErrNotFound) {
+		writeStoreError(w, err)
+		return
+	}
+	if err := a.store.Put(r.Context(), link); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", linkLocation(link))
+	writeJSON(w, http.StatusCreated, link)
+}
+
+func (a *Admin) put(w http.ResponseWriter, r *http.Request) {
+	link, ok := readLink(w, r)
+	if !ok {
+		return
+	}
+	if link.Path == "" {
+		link.Path = "/" + r.PathValue("path")
+	}
+	if link.Host == "" {
+		link.Host = r.URL.Query().Get("host")
+	}
+	link.Path = a.opts.aliases.fold(link.Path)
+	if key := a.requestKey(r); link.Key() != key {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("link %s doesn't match request path %s", link.Key(), key))
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.validate(r.Context(), link); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if existing, err := a.lookupExact(r.Context(), link.Key()); errors.Is(err, ErrNotFound) || (err == nil && existing.Deleted()) {
+		status = http.StatusCreated
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := a.store.Put(r.Context(), link); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, status, link)
+}
+
+func (a *Admin) delete(w http.ResponseWriter, r *http.Request) {
+	key := a.requestKey(r)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if r.URL.Query().Get("purge") != "true" {
+		if _, err := SoftDelete(r.Context(), a.store, key, a.actor(r), r.URL.Query().Get("reason")); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if _, err := a.lookupExact(r.Context(), key); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := a.store.Delete(r.Context(), key); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) tombstones(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.store.(Lister); !ok {
+		writeError(w, http.StatusNotImplemented, errors.New("store can't list links"))
+		return
+	}
+	tombstones, err := Tombstones(r.Context(), a.store)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if tombstones == nil {
+		tombstones = []Link{}
+	}
+	writeJSON(w, http.StatusOK, tombstones)
+}
+
+func (a *Admin) restore(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	link, err := Restore(r.Context(), a.store, a.requestKey(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, link)
+}
+
+func (a *Admin) purge(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.store.(Lister); !ok {
+		writeError(w, http.StatusNotImplemented, errors.New("store can't list links"))
+		return
+	}
+	cutoff, detail := time.Now(), "purged tombstones"
+	if v := r.URL.Query().Get("older_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid older_than %q", v))
+			return
+		}
+		cutoff, detail = cutoff.Add(-d), detail+" older than "+v
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	purged, err := PurgeTombstones(r.Context(), a.store, cutoff)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if len(purged) > 0 {
+		if err := a.Audit.Record(r.Context(), AuditEvent{Action: AuditBulk, Links: len(purged), Detail: detail}); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	if purged == nil {
+		purged = []Link{}
+	}
+	writeJSON(w, http.StatusOK, purged)
+}
+
+func (a *Admin) history(w http.ResponseWriter, r *http.Request) {
+	if a.History == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("link history is not enabled"))
+		return
+	}
+	key := a.requestKey(r)
+	revs, err := a.History.Revisions(r.Context(), key)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if len(revs) == 0 {
+		writeStoreError(w, fmt.Errorf("%w: no history for %s", ErrNotFound, key))
+		return
+	}
+	writeJSON(w, http.StatusOK, revs)
+}
+
+func (a *Admin) rollback(w http.ResponseWriter, r *http.Request) {
+	if a.History == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("link history is not enabled"))
+		return
+	}
+	v := r.URL.Query().Get("version")
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid version %q", v))
+		return
+	}
+	key := a.requestKey(r)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rev, err := findRevision(r.Context(), a.History, key, version)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if rev.New != nil && !rev.New.Deleted() {
+		if err := a.validate(r.Context(), *rev.New); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	link, err := Rollback(r.Context(), a.store, a.History, key, version)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if rev.New == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, link)
+}
+
+func (a *Admin) audit(w http.ResponseWriter, r *http.Request) {
+	if a.Audit == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("the audit log is not enabled"))
+		return
+	}
+	q := AuditQuery{Actor: r.URL.Query().Get("actor")}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := ParseLinkTime(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %v", p.name, err))
+			return
+		}
+		*p.dst = t
+	}
+	events, err := a.Audit.Query(q)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if events == nil {
+		events = []AuditEvent{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// actor returns the user making r.
+func (a *Admin) actor(r *http.Request) string {
+	if a.Actor != nil {
+		return a.Actor(r)
+	}
+	if user := r.Header.Get("X-Forwarded-User"); a.TrustProxyUser && user != "" {
+		return user
+	}
+	return "anonymous"
+}
+
+type shortenRequest struct {
+	URL  string `json:"url"`
+	Host string `json:"host,omitempty"`
+}
+
+type shortenResponse struct {
+	Link
+	ShortURL string `json:"short_url"`
+}
+
+func (a *Admin) shorten(w http.ResponseWriter, r *http.Request) {
+	var req shortenRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	var s Shortener
+	if a.Shortener != nil {
+		s = *a.Shortener
+	}
+	if s.Aliases == nil {
+		s.Aliases = &a.opts.aliases
+	}
+	// A valid URL can still make an invalid link, such as one
+	// redirecting to itself, which is reported as 422.
+	var invalid LinkErrors
+	if s.Validate == nil {
+		s.Validate = func(ctx context.Context, link Link) error {
+			err := a.validate(ctx, link)
+			errors.As(err, &invalid)
+			return err
+		}
+	}
+	a.mu.Lock()
+	link, created, err := s.Shorten(r.Context(), a.store, req.Host, req.URL)
+	a.mu.Unlock()
+	if invalid != nil {
+		writeLinkErrors(w, http.StatusUnprocessableEntity, invalid)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	short := &url.URL{Scheme: "http", Host: link.Host, Path: link.Path}
+	if r.TLS != nil {
+		short.Scheme = "https"
+	}
+	if short.Host == "" {
+		short.Host = r.Host
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		w.Header().Set("Location", linkLocation(link))
+	}
+	writeJSON(w, status, shortenResponse{Link: link, ShortURL: short.String()})
+}
+
+func (a *Admin) clickTotals(w http.ResponseWriter, r *http.Request) {
+	if a.Clicks == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("click analytics are not enabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, a.Clicks.Totals())
+}
+
+func (a *Admin) linkClicks(w http.ResponseWriter, r *http.Request) {
+	if a.Clicks == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("click analytics are not enabled"))
+		return
+	}
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid days %q", v))
+			return
+		}
+		days = n
+	}
+	since := time.Now().AddDate(0, 0, 1-days)
+	writeJSON(w, http.StatusOK, a.Clicks.Link(a.requestKey(r), since))
+}
+
+// lookupExact is like Lookup, but doesn't count a template or
+// wildcard route that happens to match key as a hit.
+func (a *Admin) lookupExact(ctx context.Context, key string) (Link, error) {
+	link, err := a.store.Lookup(ctx, key)
+	if err != nil {
+		return Link{}, err
+	}
+	if link.Key() != key {
+		return Link{}, ErrNotFound
+	}
+	return link, nil
+}
+
+// validate checks link on its own, and then for redirect
+// loops together with every other link in the store.
+func (a *Admin) validate(ctx context.Context, link Link) error {
+	if link.Match != "" {
+		return LinkErrors{{Err: errors.New("match rules can only be defined in link files")}}
+	}
+	entries := []Entry{{Link: link}}
+	if err := validateEntries(entries, &a.opts.aliases); err != nil {
+		return err
+	}
+	lister, ok := a.store.(Lister)
+	if !ok {
+		return nil
+	}
+	existing, err := lister.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, l := range existing {
+		if l.Key() != link.Key() {
+			entries = append(entries, Entry{Link: l})
+		}
+	}
+	if loops := findLoops(entries); len(loops) > 0 {
+		return loops
+	}
+	return nil
+}
+
+// requestKey returns the store key addressed by an API
+// request's {path} and host parameter.
+func (a *Admin) requestKey(r *http.Request) string {
+	return canonicalHost(r.URL.Query().Get("host")) + a.opts.aliases.fold("/"+r.PathValue("path"))
+}
+
+func linkLocation(link Link) string {
+	loc := "/api/links" + (&url.URL{Path: link.Path}).EscapedPath()
+	if link.Host != "" {
+		loc += "?host=" + url.QueryEscape(link.Host)
+	}
+	return loc
+}
+
+// readLink decodes the link in the request body. Tombstone
+// fields are dropped: links are only deleted by DELETE.
+func readLink(w http.ResponseWriter, r *http.Request) (Link, bool) {
+	var link Link
+	ok := readJSON(w, r, &link)
+	link.DeletedAt, link.DeletedBy, link.DeleteReason = nil, "", ""
+	return link, ok
+}
+
+// readJSON decodes the request body into v, writing a 400
+// response and returning false if it isn't valid.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON: %v", err))
+		return false
+	}
+	return true
+}
+
+type apiError struct {
+	Error    string   `json:"error"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// writeStoreError maps the errors returned by stores and
+// validation to a status code.
+func writeStoreError(w http.ResponseWriter, err error) {
+	var errs LinkErrors
+	switch {
+	case errors.As(err, &errs):
+		writeLinkErrors(w, http.StatusBadRequest, errs)
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrNoCode):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// writeLinkErrors lists the problems in errs.
+func writeLinkErrors(w http.ResponseWriter, status int, errs LinkErrors) {
+	body := apiError{Error: "invalid link"}
+	for _, e := range errs {
+		body.Problems = append(body.Problems, e.Err.Error())
+	}
+	writeJSON(w, status, body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
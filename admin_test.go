@@ -0,0 +1,151 @@
+package urlshort
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdmin(t *testing.T) {
+	store := NewMapStore(map[string]string{"/gh/*": "https://github.com/*"})
+	admin := NewAdmin(store)
+	do := func(method, target, body string) *http.Response {
+		t.Helper()
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		response := httptest.NewRecorder()
+		admin.ServeHTTP(response, request)
+		return response.Result()
+	}
+
+	tests := []struct {
+		name, method, target, body string
+		want                       int
+	}{
+		{"create", "POST", "/api/links", `{"path": "/a", "url": "https://a.com"}`, http.StatusCreated},
+		{"create existing", "POST", "/api/links", `{"path": "/a", "url": "https://b.com"}`, http.StatusConflict},
+		{"create invalid", "POST", "/api/links", `{"path": "/js", "url": "javascript:alert(1)"}`, http.StatusBadRequest},
+		{"create unknown field", "POST", "/api/links", `{"path": "/x", "uri": "https://x.com"}`, http.StatusBadRequest},
+		{"create loop", "POST", "/api/links", `{"host": "go.corp", "path": "/l", "url": "https://go.corp/l"}`, http.StatusBadRequest},
+		{"create ambiguous", "POST", "/api/links", `{"path": "/p/{a}", "url": "https://a.com/{a}"}`, http.StatusCreated},
+		{"create ambiguous 2", "POST", "/api/links", `{"path": "/p/{b:int}", "url": "https://b.com/{b}"}`, http.StatusConflict},
+		{"get", "GET", "/api/links/a", "", http.StatusOK},
+		{"get wildcard match", "GET", "/api/links/gh/foo", "", http.StatusNotFound},
+		{"replace", "PUT", "/api/links/a", `{"url": "https://b.com"}`, http.StatusOK},
+		{"put new", "PUT", "/api/links/help?host=go.corp", `{"url": "https://wiki.corp"}`, http.StatusCreated},
+		{"put mismatch", "PUT", "/api/links/a", `{"path": "/b", "url": "https://b.com"}`, http.StatusBadRequest},
+		{"delete", "DELETE", "/api/links/a", "", http.StatusNoContent},
+		{"delete deleted", "DELETE", "/api/links/a", "", http.StatusNotFound},
+		{"get deleted", "GET", "/api/links/a", "", http.StatusGone},
+		{"purge", "DELETE", "/api/links/a?purge=true", "", http.StatusNoContent},
+		{"get unknown", "GET", "/api/links/a", "", http.StatusNotFound},
+		{"get host-scoped", "GET", "/api/links/help?host=GO.CORP", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := do(tt.method, tt.target, tt.body); got.StatusCode != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, got.StatusCode)
+		}
+	}
+
+	var links []Link
+	if err := json.NewDecoder(do("GET", "/api/links", "").Body).Decode(&links); err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 3 {
+		t.Errorf("Expected 3 links, got %v", links)
+	}
+}
+
+func TestAdminShorten(t *testing.T) {
+	store := NewMapStore(map[string]string{"/s/0": "https://taken.com"})
+	admin := NewAdmin(store)
+	admin.Shortener = &Shortener{Codes: SequentialCodes(0), Prefix: "/s/"}
+	shorten := func(body string) (*http.Response, shortenResponse) {
+		t.Helper()
+		request := httptest.NewRequest("POST", "http://sho.rt/api/shorten", strings.NewReader(body))
+		response := httptest.NewRecorder()
+		admin.ServeHTTP(response, request)
+		var got shortenResponse
+		json.NewDecoder(response.Body).Decode(&got)
+		return response.Result(), got
+	}
+
+	resp, got := shorten(`{"url": "https://example.com/a/very/long/url"}`)
+	assertStatus(t, resp, http.StatusCreated)
+	if got.Path != "/s/1" || got.ShortURL != "http://sho.rt/s/1" {
+		t.Errorf("Expected /s/1 skipping the taken /s/0, got %+v", got)
+	}
+	assertLookup(t, store, "/s/1", "https://example.com/a/very/long/url")
+
+	resp, _ = shorten(`{"url": "javascript:alert(1)"}`)
+	assertStatus(t, resp, http.StatusBadRequest)
+
+	// The next code is 2, so this link would redirect to itself.
+	resp, _ = shorten(`{"url": "http://sho.rt/s/2", "host": "sho.rt"}`)
+	assertStatus(t, resp, http.StatusUnprocessableEntity)
+	resp, _ = shorten(`{"url": "https://example.com", "host": "bad host/x"}`)
+	assertStatus(t, resp, http.StatusUnprocessableEntity)
+	if links, _ := store.List(context.Background()); len(links) != 2 {
+		t.Errorf("Expected no invalid links to be stored, got %v", links)
+	}
+}
+
+func TestShortenReuse(t *testing.T) {
+	ctx := context.Background()
+	long := "https://example.com/a/very/long/url"
+	shortener := &Shortener{Codes: HashCodes(7)}
+	store := NewMapStore(nil)
+	first, created, err := shortener.Shorten(ctx, store, "", long)
+	if err != nil || !created {
+		t.Fatalf("Expected a new link, got %v, %v", created, err)
+	}
+	if again, created, err := shortener.Shorten(ctx, store, "", long); err != nil || created || again.Path != first.Path {
+		t.Errorf("Expected %s to be reused, got %v, %v, %v", first.Path, again, created, err)
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	first.ExpiresAt = &expired
+	store.Put(ctx, first)
+	again, created, err := shortener.Shorten(ctx, store, "", long)
+	if err != nil || !created || again.Path == first.Path {
+		t.Errorf("Expected a new link instead of the expired %s, got %v, %v, %v", first.Path, again, created, err)
+	}
+}
+
+func TestCodeStrategies(t *testing.T) {
+	next := SequentialCodes(61)
+	if a, b := next("", 0), next("", 0); a != "Z" || b != "10" {
+		t.Errorf("Expected Z then 10, got %s then %s", a, b)
+	}
+	hash := HashCodes(8)
+	if a, b := hash("https://a.com", 0), hash("https://a.com", 0); a != b || len(a) != 8 {
+		t.Errorf("Expected a stable 8 character hash code, got %s and %s", a, b)
+	}
+	if hash("https://a.com", 0) == hash("https://a.com", 1) {
+		t.Error("Expected a different hash code on retry")
+	}
+	if code := RandomCodes(5)("", 0); len(code) != 5 {
+		t.Errorf("Expected a 5 character random code, got %s", code)
+	}
+	for _, tc := range []struct {
+		spec   string
+		length int
+	}{{"random:4", 4}, {"hash", 7}, {"sequential:61", 1}} {
+		codes, err := ParseCodeStrategy(tc.spec)
+		if err != nil {
+			t.Errorf("ParseCodeStrategy(%q): %v", tc.spec, err)
+			continue
+		}
+		if code := codes("https://a.com", 0); len(code) != tc.length {
+			t.Errorf("ParseCodeStrategy(%q) generated %q, want %d characters", tc.spec, code, tc.length)
+		}
+	}
+	for _, spec := range []string{"random:0", "hash:x", "uuid"} {
+		if _, err := ParseCodeStrategy(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
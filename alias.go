@@ -0,0 +1,142 @@
+package urlshort
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// AliasPolicy restricts the paths that links may use, so that
+// hand-picked aliases can't shadow the paths the fallback
+// handler serves or use characters that need escaping. It is
+// enforced when links are loaded from files and when they are
+// created through Admin; see WithAliasPolicy.
+type AliasPolicy struct {
+	// Reserved lists paths links may not use. Each one also
+	// reserves everything below it, so "/api" rules out
+	// /api/links too, except for "/" which only reserves the
+	// root itself. A wildcard may not cover a reserved path
+	// either, so /* is always rejected. Reserved paths are
+	// compared without regard to case.
+	Reserved []string
+	// Charset is the set of characters allowed in the literal
+	// segments of a path, written as the inside of a regexp
+	// character class such as `a-z0-9-`. Placeholders and
+	// wildcards are not checked. If empty, any character is
+	// allowed.
+	Charset string
+	// MinLength and MaxLength bound the length of an exact
+	// path in characters, not counting its leading slash.
+	// Template and wildcard paths are not checked. Zero means
+	// no bound.
+	MinLength, MaxLength int
+	// FoldCase makes paths case-insensitive: paths are
+	// lowercased when links are loaded or created, and a
+	// request path that misses is looked up again lowercased.
+	FoldCase bool
+}
+
+// DefaultAliasPolicy is the policy used unless another one is
+// set with WithAliasPolicy. It reserves the paths a typical
+// deployment serves itself and allows the URL "unreserved"
+// characters. To extend it, copy the Reserved slice before
+// appending to it.
+var DefaultAliasPolicy = AliasPolicy{
+	Reserved:  []string{"/", "/api", "/static", "/healthz", "/metrics"},
+	Charset:   `A-Za-z0-9._~-`,
+	MinLength: 1,
+	MaxLength: 256,
+}
+
+// Check reports whether path is allowed by p, with an error
+// describing the first rule it breaks.
+func (p *AliasPolicy) Check(path string) error {
+	for _, reserved := range p.Reserved {
+		if strings.EqualFold(path, reserved) {
+			return fmt.Errorf("path %s is reserved", path)
+		}
+		if prefix := strings.TrimSuffix(reserved, "/") + "/"; prefix != "/" && hasPrefixFold(path, prefix) {
+			return fmt.Errorf("path %s is under the reserved path %s", path, reserved)
+		}
+		if !isWildcard(path) {
+			continue
+		}
+		if prefix := wildcardPrefix(path); strings.EqualFold(reserved, strings.TrimSuffix(prefix, "/")) || hasPrefixFold(reserved, prefix) {
+			return fmt.Errorf("path %s covers the reserved path %s", path, reserved)
+		}
+	}
+	if !isWildcard(path) && !strings.Contains(path, "{") {
+		n := utf8.RuneCountInString(strings.TrimPrefix(path, "/"))
+		if p.MinLength > 0 && n < p.MinLength {
+			return fmt.Errorf("path %s is shorter than %d characters", path, p.MinLength)
+		}
+		if p.MaxLength > 0 && n > p.MaxLength {
+			return fmt.Errorf("path %s is longer than %d characters", path, p.MaxLength)
+		}
+	}
+	if p.Charset != "" {
+		re, err := compileCharset(p.Charset)
+		if err != nil {
+			return err
+		}
+		for _, seg := range literalSegments(path) {
+			if !re.MatchString(seg) {
+				return fmt.Errorf("path %s has characters outside [%s] in %q", path, p.Charset, seg)
+			}
+		}
+	}
+	return nil
+}
+
+// charsets caches the compiled Charset of each policy, so
+// that checking a path doesn't compile a regexp.
+var charsets sync.Map // charset -> *regexp.Regexp
+
+// compileCharset returns a regexp matching strings made only
+// of the characters in charset.
+func compileCharset(charset string) (*regexp.Regexp, error) {
+	if re, ok := charsets.Load(charset); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile("^[" + charset + "]*$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid alias charset %q: %v", charset, err)
+	}
+	charsets.Store(charset, re)
+	return re, nil
+}
+
+// fold returns path lowercased if p folds case, leaving
+// template placeholders alone so they still match the names
+// used in the URL.
+func (p *AliasPolicy) fold(path string) string {
+	if !p.FoldCase {
+		return path
+	}
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		if !strings.HasPrefix(seg, "{") {
+			segs[i] = strings.ToLower(seg)
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// literalSegments returns the segments of path that aren't a
+// placeholder or a trailing wildcard.
+func literalSegments(path string) []string {
+	var segs []string
+	for _, seg := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		if strings.HasPrefix(seg, "{") || seg == wildcard {
+			continue
+		}
+		segs = append(segs, seg)
+	}
+	return segs
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
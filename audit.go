@@ -0,0 +1,246 @@
+package urlshort
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An AuditLog is an append-only record of the administrative
+// changes made to a set of links, for answering who changed
+// what and when. It is an NDJSON file with one AuditEvent per
+// line:
+//
+//     {"time": "...", "actor": "alice", "action": "update", "key": "/a", "old": {...}, "new": {...}}
+//     {"time": "...", "action": "reload", "source": "links.yaml", "links": 12}
+//
+// Changes to single links are recorded by a RecordingStore,
+// reloads of a FileStore given WithAuditLog (the file-backed
+// counterpart of YAMLHandler) on their own, and bulk
+// operations such as purges by Admin and the urlshort command.
+//
+// When the file grows past its maximum size it is renamed
+// with the time of the rotation appended, as in
+// audit.ndjson.20250501T093000.000000000Z, and a new one is
+// started. Rotated files are never removed or rewritten;
+// keeping them as long as needed is up to the operator.
+// Several processes may append to the same log.
+
+// The actions of audit events that aren't changes to a single
+// link, whose actions are the RevisionOps.
+const (
+	AuditImport = "import" // links loaded from files into a store
+	AuditBulk   = "bulk"   // a change to many links, such as a purge
+	AuditReload = "reload" // a FileStore reading its file
+)
+
+// An AuditEvent is one entry of an AuditLog.
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor,omitempty"`
+	Action string    `json:"action"`
+	// Key, Old and New describe a change to a single link, as
+	// in a Revision.
+	Key string `json:"key,omitempty"`
+	Old *Link  `json:"old,omitempty"`
+	New *Link  `json:"new,omitempty"`
+	// Source is the file reloaded or imported, and Links how
+	// many links it had or the operation changed.
+	Source string `json:"source,omitempty"`
+	Links  int    `json:"links,omitempty"`
+	// Detail says more about a bulk operation, and Error why
+	// a reload failed.
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AuditQuery selects the events returned by AuditLog.Query.
+// Zero fields match every event.
+type AuditQuery struct {
+	Actor string
+	Since time.Time // events at or after Since
+	Until time.Time // events before Until
+}
+
+func (q AuditQuery) match(ev AuditEvent) bool {
+	return (q.Actor == "" || ev.Actor == q.Actor) &&
+		(q.Since.IsZero() || !ev.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || ev.Time.Before(q.Until))
+}
+
+// rotatedFormat is the time appended to rotated files.
+const rotatedFormat = "20060102T150405.000000000Z"
+
+// AuditLog appends events to an NDJSON file. Record may be
+// called on a nil *AuditLog, and then does nothing.
+type AuditLog struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenAuditLog opens the audit log at path, creating it if
+// needed, and rotates it whenever it would grow past maxSize
+// bytes. If maxSize is not positive, it is never rotated.
+func OpenAuditLog(path string, maxSize int64) (*AuditLog, error) {
+	l := &AuditLog{path: path, maxSize: maxSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	return nil
+}
+
+// WithAuditLog makes a FileStore record every load and reload
+// of its file in log, including failed ones.
+func WithAuditLog(log *AuditLog) Option {
+	return func(o *options) {
+		o.audit = log
+	}
+}
+
+// Record appends ev to the log. A zero Time is set to now, and
+// an empty Actor to the actor of ctx (see ContextWithActor).
+func (l *AuditLog) Record(ctx context.Context, ev AuditEvent) error {
+	if l == nil {
+		return nil
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	if ev.Actor == "" {
+		ev.Actor = ActorFromContext(ctx)
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return errors.New("urlshort: audit log is closed")
+	}
+	if err := l.rotate(int64(len(data))); err != nil {
+		return fmt.Errorf("urlshort: rotating %s: %w", l.path, err)
+	}
+	_, err = l.file.Write(data)
+	return err
+}
+
+// rotate starts a new file if writing n more bytes would take
+// the current one past maxSize. It also reopens the file if
+// another process rotated it.
+func (l *AuditLog) rotate(n int64) error {
+	current, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(l.path); err != nil || !os.SameFile(fi, current) {
+		l.file.Close()
+		if err := l.open(); err != nil {
+			return err
+		}
+		if current, err = l.file.Stat(); err != nil {
+			return err
+		}
+	}
+	if l.maxSize <= 0 || current.Size() == 0 || current.Size()+n <= l.maxSize {
+		return nil
+	}
+	rotated := l.path + "." + time.Now().UTC().Format(rotatedFormat)
+	if err := os.Rename(l.path, rotated); err != nil {
+		return err
+	}
+	l.file.Close()
+	return l.open()
+}
+
+// Query returns the events matching q from the log and the
+// files rotated out of it, oldest first.
+func (l *AuditLog) Query(q AuditQuery) ([]AuditEvent, error) {
+	rotated, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rotated)
+	var events []AuditEvent
+	for _, path := range append(rotated, l.path) {
+		suffix := strings.TrimPrefix(path, l.path+".")
+		if t, err := time.Parse(rotatedFormat, suffix); err == nil && !q.Since.IsZero() && t.Before(q.Since) {
+			continue // rotated before anything we want happened
+		}
+		events, err = readAudit(path, q, events)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// readAudit appends the events in the file at path that match
+// q to events.
+func readAudit(path string, q AuditQuery, events []AuditEvent) ([]AuditEvent, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return events, nil // rotated away meanwhile
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial last line is still being written.
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var ev AuditEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return nil, fmt.Errorf("urlshort: %s:%d: %w", path, n, err)
+		}
+		if q.match(ev) {
+			events = append(events, ev)
+		}
+	}
+}
+
+// Close closes the log; Record fails after that.
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,130 @@
+package urlshort
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	log, err := OpenAuditLog(path, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	store := NewRecordingStore(NewMapStore(nil), nil, log)
+	alice := ContextWithActor(context.Background(), "alice")
+	bob := ContextWithActor(context.Background(), "bob")
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		store.Put(alice, Link{Path: "/a", URL: "https://a.com/" + string(rune('0'+i))})
+	}
+	store.Put(alice, Link{Path: "/a", URL: "https://a.com/4"}) // no change
+	if _, err := SoftDelete(bob, store, "/a", "bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	log.Record(context.Background(), AuditEvent{Action: AuditBulk, Links: 3, Detail: "test"})
+
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) == 0 {
+		t.Error("Expected the log to be rotated")
+	}
+
+	t.Run("it returns every event in order", func(t *testing.T) {
+		events, err := log.Query(AuditQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 7 {
+			t.Fatalf("Expected 7 events across the rotated files, got %d: %v", len(events), events)
+		}
+		if events[0].Action != string(OpCreate) || events[1].Action != string(OpUpdate) || events[5].Action != string(OpDelete) {
+			t.Errorf("Unexpected actions %v", events)
+		}
+		if events[1].Old.URL != "https://a.com/0" || events[1].New.URL != "https://a.com/1" {
+			t.Errorf("Expected the old and new link, got %v", events[1])
+		}
+	})
+
+	t.Run("it filters by actor and time", func(t *testing.T) {
+		events, _ := log.Query(AuditQuery{Actor: "bob"})
+		if len(events) != 1 || events[0].Key != "/a" {
+			t.Errorf("Expected bob's delete, got %v", events)
+		}
+		if events, _ := log.Query(AuditQuery{Since: time.Now()}); len(events) != 0 {
+			t.Errorf("Expected no events from now on, got %v", events)
+		}
+		if events, _ := log.Query(AuditQuery{Until: start}); len(events) != 0 {
+			t.Errorf("Expected no events before the start, got %v", events)
+		}
+	})
+}
+
+func TestAuditReload(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenAuditLog(filepath.Join(dir, "audit.ndjson"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	path := filepath.Join(dir, "links.yaml")
+	writeFile(t, path, "- path: /a\n  url: https://a.com\n")
+	store, err := NewFileStore(path, WithAuditLog(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, "- path: /a\n  url: ftp://a.com\n")
+	store.Reload()
+
+	events, err := log.Query(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Action != AuditReload || events[0].Links != 1 || events[0].Source != path {
+		t.Fatalf("Expected 2 reloads, got %v", events)
+	}
+	if events[1].Error == "" {
+		t.Errorf("Expected the failed reload to be recorded, got %v", events[1])
+	}
+}
+
+func TestAdminAudit(t *testing.T) {
+	log, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.ndjson"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	admin := NewAdmin(NewRecordingStore(NewMapStore(map[string]string{"/a": "https://a.com"}), nil, log))
+	admin.TrustProxyUser = true
+	do := func(method, target, user string) *http.Response {
+		t.Helper()
+		request := httptest.NewRequest(method, target, nil)
+		request.Header.Set("X-Forwarded-User", user)
+		response := httptest.NewRecorder()
+		admin.ServeHTTP(response, request)
+		return response.Result()
+	}
+
+	assertStatus(t, do("GET", "/api/audit", "frank"), http.StatusNotImplemented)
+	admin.Audit = log
+	assertStatus(t, do("DELETE", "/api/links/a", "frank"), http.StatusNoContent)
+	assertStatus(t, do("POST", "/api/tombstones/purge", "grace"), http.StatusOK)
+
+	var events []AuditEvent
+	json.NewDecoder(do("GET", "/api/audit?actor=grace", "frank").Body).Decode(&events)
+	// The purge itself and the link it removed.
+	if len(events) != 2 || events[0].Action != string(OpPurge) || events[1].Action != AuditBulk || events[1].Links != 1 {
+		t.Errorf("Unexpected events for grace %v", events)
+	}
+	json.NewDecoder(do("GET", "/api/audit?since=2000-01-01&until="+time.Now().Add(time.Hour).UTC().Format(time.RFC3339), "frank").Body).Decode(&events)
+	if len(events) != 3 {
+		t.Errorf("Expected 3 events, got %v", events)
+	}
+	assertStatus(t, do("GET", "/api/audit?since=yesterday", "frank"), http.StatusBadRequest)
+}
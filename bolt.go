@@ -0,0 +1,391 @@
+package urlshort
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore is a MutableStore and Lister that keeps links in a
+// Bolt database file. Links are stored as JSON under their key
+// in the "links" bucket, so looking up an exact path is a
+// single Get rather than a scan; match rules live in the
+// "rules" bucket in the order they were added. Templates,
+// wildcards and rules are also indexed in memory when the
+// store is opened, so patterns are matched without touching
+// the database.
+//
+// The "meta" bucket records the schema version. Databases
+// written by earlier versions, including the single-bucket
+// layouts of the form
+//
+//     Bucket(paths | pairs | pathstourls)
+//         /some-path -> https://www.some-url.com/demo
+//
+// are migrated when they are opened.
+type BoltStore struct {
+	db   *bolt.DB
+	path string
+	opts *options
+
+	mu       sync.Mutex               // serializes writes with updates to patterns
+	patterns atomic.Pointer[MapStore] // templates, wildcards and rules
+}
+
+var (
+	boltMeta  = []byte("meta")
+	boltLinks = []byte("links")
+	boltRules = []byte("rules")
+
+	boltVersionKey = []byte("schema_version")
+)
+
+// boltMigrations upgrade a database one schema version at a
+// time: boltMigrations[i] moves it from version i to i+1. A
+// database without a meta bucket is version 0.
+var boltMigrations = []func(tx *bolt.Tx) error{
+	migrateBoltLegacy,
+}
+
+// boltSchemaVersion is the version this package writes.
+var boltSchemaVersion = len(boltMigrations)
+
+// legacyBoltBuckets are the buckets of plain path -> URL pairs
+// used before the schema was versioned.
+var legacyBoltBuckets = []string{"paths", "pairs", "pathstourls"}
+
+// OpenBoltStore opens the Bolt database at path, creating it
+// if needed and migrating it to the current schema. It gives
+// up after a second if another process has the database open.
+// Paths are checked against the alias policy set with
+// WithAliasPolicy when links are added. With WithMetrics, the
+// links gauge is set on opening and after every change, under
+// the name set with WithSource or else path.
+func OpenBoltStore(path string, opts ...Option) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("urlshort: opening %s: %w", path, err)
+	}
+	s := &BoltStore{db: db, path: path, opts: newOptions(opts)}
+	if err := db.Update(migrateBolt); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("urlshort: %s: %w", path, err)
+	}
+	if err := s.loadPatterns(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("urlshort: %s: %w", path, err)
+	}
+	s.opts.metrics.loadedStore(context.Background(), s.opts.sourceName(path), s)
+	return s, nil
+}
+
+// Close closes the database.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// Lookup returns the link stored for key, or failing that the
+// first rule, template or wildcard matching it, in the same
+// order as MapStore.
+func (s *BoltStore) Lookup(ctx context.Context, key string) (Link, error) {
+	var link Link
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltLinks).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &link)
+	})
+	if err != nil {
+		return Link{}, fmt.Errorf("urlshort: reading %s: %w", key, err)
+	}
+	if found {
+		return link, nil
+	}
+	return s.patterns.Load().Lookup(ctx, key)
+}
+
+// Put validates link and creates or replaces it in a single
+// transaction. A link with a Match pattern replaces the rule
+// with the same pattern and host, or is added after all
+// existing rules.
+func (s *BoltStore) Put(ctx context.Context, link Link) error {
+	return s.PutAll(ctx, []Link{link})
+}
+
+// PutAll validates links together and puts them as Put does,
+// all in a single transaction.
+func (s *BoltStore) PutAll(ctx context.Context, links []Link) error {
+	if err := validateLinks(links, &s.opts.aliases); err != nil {
+		return err
+	}
+	data := make([][]byte, len(links))
+	for i, link := range links {
+		var err error
+		if data[i], err = json.Marshal(link); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Check for ambiguous templates before writing, but only
+	// serve the new patterns once they are written.
+	patterns, err := withPatterns(ctx, s.patterns.Load(), links)
+	if err != nil {
+		return err
+	}
+	var failed Link
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for i, link := range links {
+			failed = link
+			if err := putBoltLink(tx, link, data[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("urlshort: writing %s: %w", failed.Key(), err)
+	}
+	if patterns != nil {
+		s.patterns.Store(patterns)
+	}
+	s.opts.metrics.recount(ctx, s.opts.sourceName(s.path), s)
+	return nil
+}
+
+// putBoltLink writes link, marshaled as data, in tx.
+func putBoltLink(tx *bolt.Tx, link Link, data []byte) error {
+	if link.Match == "" {
+		return tx.Bucket(boltLinks).Put([]byte(link.Key()), data)
+	}
+	rules := tx.Bucket(boltRules)
+	key, err := findBoltRule(rules, link)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		seq, err := rules.NextSequence()
+		if err != nil {
+			return err
+		}
+		key = boltSeqKey(seq)
+	}
+	return rules.Put(key, data)
+}
+
+// Delete removes the link for key.
+func (s *BoltStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var old Link
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		links := tx.Bucket(boltLinks)
+		data := links.Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(data, &old); err != nil {
+			return err
+		}
+		return links.Delete([]byte(key))
+	})
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return fmt.Errorf("urlshort: deleting %s: %w", key, err)
+	}
+	if isPattern(old) {
+		s.patterns.Load().Delete(ctx, key)
+	}
+	s.opts.metrics.recount(ctx, s.opts.sourceName(s.path), s)
+	return nil
+}
+
+// DeleteRule removes the rule with the given pattern for host.
+func (s *BoltStore) DeleteRule(ctx context.Context, host, match string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	patterns := copyPatterns(ctx, s.patterns.Load())
+	if err := patterns.DeleteRule(ctx, host, match); err != nil {
+		return err
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		rules := tx.Bucket(boltRules)
+		key, err := findBoltRule(rules, Link{Host: host, Match: match})
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return ErrNotFound
+		}
+		return rules.Delete(key)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: match %s", ErrNotFound, match)
+	}
+	if err != nil {
+		return fmt.Errorf("urlshort: deleting match %s: %w", match, err)
+	}
+	s.patterns.Store(patterns)
+	s.opts.metrics.recount(ctx, s.opts.sourceName(s.path), s)
+	return nil
+}
+
+// List returns every link sorted by key, followed by the match
+// rules in the order they are tried.
+func (s *BoltStore) List(ctx context.Context) ([]Link, error) {
+	var links []Link
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltLinks, boltRules} {
+			err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+				var link Link
+				if err := json.Unmarshal(v, &link); err != nil {
+					return fmt.Errorf("%s: %w", k, err)
+				}
+				links = append(links, link)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("urlshort: listing links: %w", err)
+	}
+	return links, nil
+}
+
+// migrateBolt brings the database in tx up to the current
+// schema version, creating the buckets of a new database.
+func migrateBolt(tx *bolt.Tx) error {
+	version := 0
+	if meta := tx.Bucket(boltMeta); meta != nil {
+		v, err := strconv.Atoi(string(meta.Get(boltVersionKey)))
+		if err != nil {
+			return fmt.Errorf("invalid schema version %q", meta.Get(boltVersionKey))
+		}
+		version = v
+	}
+	if version > boltSchemaVersion {
+		return fmt.Errorf("schema version %d is newer than the supported version %d", version, boltSchemaVersion)
+	}
+	for ; version < boltSchemaVersion; version++ {
+		if err := boltMigrations[version](tx); err != nil {
+			return fmt.Errorf("migrating from schema version %d: %w", version, err)
+		}
+		meta, err := tx.CreateBucketIfNotExists(boltMeta)
+		if err != nil {
+			return err
+		}
+		if err := meta.Put(boltVersionKey, []byte(strconv.Itoa(version+1))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBoltLegacy creates the links and rules buckets and
+// moves the pairs of any legacy bucket into them. Where two
+// legacy buckets have the same path, the first one in
+// legacyBoltBuckets wins.
+func migrateBoltLegacy(tx *bolt.Tx) error {
+	links, err := tx.CreateBucketIfNotExists(boltLinks)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.CreateBucketIfNotExists(boltRules); err != nil {
+		return err
+	}
+	for _, name := range legacyBoltBuckets {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			continue
+		}
+		err := b.ForEach(func(k, v []byte) error {
+			if links.Get(k) != nil {
+				return nil
+			}
+			data, err := json.Marshal(Link{Path: string(k), URL: string(v)})
+			if err != nil {
+				return err
+			}
+			return links.Put(k, data)
+		})
+		if err != nil {
+			return fmt.Errorf("bucket %s: %w", name, err)
+		}
+		if err := tx.DeleteBucket([]byte(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPatterns indexes the templates, wildcards and rules in
+// the database.
+func (s *BoltStore) loadPatterns() error {
+	patterns := NewMapStore(nil)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltLinks, boltRules} {
+			err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+				var link Link
+				if err := json.Unmarshal(v, &link); err != nil {
+					return fmt.Errorf("%s: %w", k, err)
+				}
+				if !isPattern(link) {
+					return nil
+				}
+				return patterns.Put(context.Background(), link)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.patterns.Store(patterns)
+	return nil
+}
+
+// findBoltRule returns the key of the rule in b with the same
+// pattern and host as link, or nil if there is none.
+func findBoltRule(b *bolt.Bucket, link Link) ([]byte, error) {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var rule Link
+		if err := json.Unmarshal(v, &rule); err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		if rule.Match == link.Match && rule.Key() == link.Key() {
+			return bytes.Clone(k), nil
+		}
+	}
+	return nil, nil
+}
+
+// boltSeqKey encodes a bucket sequence number as a big-endian
+// key, so that cursors visit rules in the order they were
+// added.
+func boltSeqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
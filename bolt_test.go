@@ -0,0 +1,134 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.db")
+	store, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := []Link{
+		{Path: "/a", URL: "https://a.com"},
+		{Host: "go.corp", Path: "/a", URL: "https://intranet.example.com/a", Status: 301},
+		{Path: "/gh/*", URL: "https://github.com/*"},
+		{Path: "/jira/{id}", URL: "https://jira.example.com/browse/{id}"},
+		{Match: `^/v(\d+)$`, URL: "https://example.com/version/$1"},
+	}
+	for _, link := range links {
+		if err := store.Put(ctx, link); err != nil {
+			t.Fatalf("Put(%v): %v", link, err)
+		}
+	}
+	if err := store.Put(ctx, Link{Path: "/jira/{key}", URL: "https://jira.example.com/{key}"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict for an ambiguous template, got %v", err)
+	}
+	var errs LinkErrors
+	if err := store.Put(ctx, Link{Path: "/b", URL: "javascript:alert(1)"}); !errors.As(err, &errs) {
+		t.Errorf("Expected LinkErrors for an invalid link, got %v", err)
+	}
+	if err := store.Delete(ctx, "/nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting a missing link, got %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	assertLookup(t, store, "/a", "https://a.com")
+	assertLookup(t, store, "go.corp/a", "https://intranet.example.com/a")
+	assertLookup(t, store, "/gh/gophercises", "https://github.com/gophercises")
+	assertLookup(t, store, "/jira/ABC-1", "https://jira.example.com/browse/ABC-1")
+	assertLookup(t, store, "/v2", "https://example.com/version/2")
+
+	if err := store.Delete(ctx, "/gh/*"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lookup(ctx, "/gh/gophercises"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the wildcard to be gone, got %v", err)
+	}
+	got, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Link{links[0], links[3], links[1], links[4]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func TestBoltStoreMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := map[string]map[string]string{
+		"paths":       {"/urlshort": "https://github.com/gophercises/urlshort"},
+		"pairs":       {"/wi": "https://ru.wikipedia.org", "/urlshort": "https://example.com"},
+		"pathstourls": {"/yaml-godoc": "https://godoc.org/gopkg.in/yaml.v2"},
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for name, pairs := range legacy {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for k, v := range pairs {
+				if err := b.Put([]byte(k), []byte(v)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLookup(t, store, "/urlshort", "https://github.com/gophercises/urlshort")
+	assertLookup(t, store, "/wi", "https://ru.wikipedia.org")
+	assertLookup(t, store, "/yaml-godoc", "https://godoc.org/gopkg.in/yaml.v2")
+	err = store.db.View(func(tx *bolt.Tx) error {
+		for name := range legacy {
+			if tx.Bucket([]byte(name)) != nil {
+				t.Errorf("Expected bucket %s to be removed", name)
+			}
+		}
+		if v := string(tx.Bucket(boltMeta).Get(boltVersionKey)); v != "1" {
+			t.Errorf("Expected schema version 1, got %q", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A database from a newer version is refused.
+	store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMeta).Put(boltVersionKey, []byte("99"))
+	})
+	store.Close()
+	if _, err := OpenBoltStore(path); err == nil {
+		t.Error("Expected an error opening a database with a newer schema")
+	}
+}
@@ -0,0 +1,213 @@
+package urlshort
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errReadOnly is returned by CachedStore writes when the store
+// it wraps is not a MutableStore, or lists when it isn't a
+// Lister.
+var errReadOnly = errors.New("urlshort: the cached store doesn't support this operation")
+
+// CachedStore is a Store that remembers the results of
+// lookups in another store, so that popular links and
+// repeated misses (which go on to the fallback handler) don't
+// hit a slow backend on every request.
+//
+// At most size keys are cached; the least recently used one
+// is evicted to make room for a new one. Links are cached for
+// ttl and ErrNotFound results for negativeTTL, if it is
+// positive. Other errors are never cached.
+//
+// Put and Delete go through to the wrapped store, which must
+// then be a MutableStore, and invalidate the affected keys: a
+// change to an exact link drops just its key, while a change
+// to a wildcard, template or rule drops everything. Changes
+// made to the wrapped store directly are only seen once the
+// cached results expire, or after Purge.
+type CachedStore struct {
+	store            Store
+	size             int
+	ttl, negativeTTL time.Duration
+	now              func() time.Time // for tests
+
+	hits, negativeHits, misses, evictions atomic.Int64
+
+	mu    sync.Mutex
+	lru   *list.List // of *cacheEntry, most recently used first
+	items map[string]*list.Element
+	gen   uint64 // bumped by every invalidation
+}
+
+type cacheEntry struct {
+	key     string
+	link    Link
+	err     error // ErrNotFound, or nil
+	expires time.Time
+}
+
+// CacheStats are the counters of a CachedStore.
+type CacheStats struct {
+	Hits         int64 // lookups answered from the cache
+	NegativeHits int64 // the part of Hits that were cached misses
+	Misses       int64 // lookups passed on to the wrapped store
+	Evictions    int64 // entries dropped to make room
+	Size         int   // entries cached now
+}
+
+// NewCachedStore returns a CachedStore in front of store. It
+// panics if size is not positive.
+func NewCachedStore(store Store, size int, ttl, negativeTTL time.Duration) *CachedStore {
+	if size <= 0 {
+		panic("urlshort: NewCachedStore needs a positive size")
+	}
+	return &CachedStore{
+		store:       store,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		lru:         list.New(),
+		items:       make(map[string]*list.Element),
+	}
+}
+
+// Lookup returns the cached result for key, or looks it up in
+// the wrapped store and caches the result.
+func (c *CachedStore) Lookup(ctx context.Context, key string) (Link, error) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*cacheEntry)
+		if c.now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			if e.err != nil {
+				c.negativeHits.Add(1)
+			}
+			return e.link, e.err
+		}
+		c.remove(el)
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	link, err := c.store.Lookup(ctx, key)
+	ttl := c.ttl
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) || c.negativeTTL <= 0 {
+			return link, err
+		}
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return link, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		// A write happened while we were looking; what we
+		// found may already be stale.
+		return link, err
+	}
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.lru.PushFront(&cacheEntry{key: key, link: link, err: err, expires: c.now().Add(ttl)})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+	return link, err
+}
+
+// Put writes link to the wrapped store and invalidates the
+// keys it affects.
+func (c *CachedStore) Put(ctx context.Context, link Link) error {
+	ms, ok := c.store.(MutableStore)
+	if !ok {
+		return errReadOnly
+	}
+	err := ms.Put(ctx, link)
+	c.invalidate(link)
+	return err
+}
+
+// Delete deletes key from the wrapped store and invalidates
+// the keys it affects.
+func (c *CachedStore) Delete(ctx context.Context, key string) error {
+	ms, ok := c.store.(MutableStore)
+	if !ok {
+		return errReadOnly
+	}
+	// Find out what kind of link is going away before it is
+	// gone, since a pattern needs a full purge.
+	old, lookupErr := ms.Lookup(ctx, key)
+	err := ms.Delete(ctx, key)
+	if lookupErr != nil || old.Key() != key {
+		old = Link{Path: key}
+	}
+	c.invalidate(old)
+	return err
+}
+
+// List returns the links of the wrapped store, which must be
+// a Lister. It is not cached.
+func (c *CachedStore) List(ctx context.Context) ([]Link, error) {
+	l, ok := c.store.(Lister)
+	if !ok {
+		return nil, errReadOnly
+	}
+	return l.List(ctx)
+}
+
+// Purge empties the cache.
+func (c *CachedStore) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// Stats returns the cache counters.
+func (c *CachedStore) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Size:         size,
+	}
+}
+
+// invalidate drops the cached results that a change to link
+// could affect.
+func (c *CachedStore) invalidate(link Link) {
+	if isPattern(link) {
+		c.Purge()
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if el, ok := c.items[link.Key()]; ok {
+		c.remove(el)
+	}
+}
+
+// remove drops el from the cache. The caller must hold c.mu.
+func (c *CachedStore) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).key)
+}
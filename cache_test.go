@@ -0,0 +1,75 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCachedStore(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{MapStore: NewMapStore(map[string]string{"/a": "https://a.com", "/b": "https://b.com"})}
+	cache := NewCachedStore(backend, 2, time.Minute, time.Second)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	assertLookup(t, cache, "/a", "https://a.com")
+	assertLookup(t, cache, "/a", "https://a.com")
+	if _, err := cache.Lookup(ctx, "/nope"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := cache.Lookup(ctx, "/nope"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected a cached ErrNotFound, got %v", err)
+	}
+	if lookups := backend.lookups; lookups != 2 {
+		t.Errorf("Expected 2 lookups in the backend, got %d", lookups)
+	}
+
+	t.Run("it expires misses after the negative TTL", func(t *testing.T) {
+		now = now.Add(2 * time.Second)
+		cache.Lookup(ctx, "/nope")
+		assertLookup(t, cache, "/a", "https://a.com")
+		if lookups := backend.lookups; lookups != 3 {
+			t.Errorf("Expected 3 lookups in the backend, got %d", lookups)
+		}
+	})
+
+	t.Run("it evicts the least recently used key", func(t *testing.T) {
+		assertLookup(t, cache, "/b", "https://b.com") // evicts /nope
+		assertLookup(t, cache, "/a", "https://a.com")
+		if lookups := backend.lookups; lookups != 4 {
+			t.Errorf("Expected 4 lookups in the backend, got %d", lookups)
+		}
+	})
+
+	t.Run("it invalidates keys on writes", func(t *testing.T) {
+		if err := cache.Put(ctx, Link{Path: "/a", URL: "https://a.org"}); err != nil {
+			t.Fatal(err)
+		}
+		assertLookup(t, cache, "/a", "https://a.org")
+		if err := cache.Put(ctx, Link{Path: "/b/*", URL: "https://b.org/*"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.Delete(ctx, "/b"); err != nil {
+			t.Fatal(err)
+		}
+		assertLookup(t, cache, "/b", "https://b.org/")
+	})
+
+	want := CacheStats{Hits: 4, NegativeHits: 1, Misses: 6, Evictions: 1, Size: 1}
+	if got := cache.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+// countingStore counts the lookups that reach a MapStore.
+type countingStore struct {
+	*MapStore
+	lookups int
+}
+
+func (s *countingStore) Lookup(ctx context.Context, key string) (Link, error) {
+	s.lookups++
+	return s.MapStore.Lookup(ctx, key)
+}
@@ -0,0 +1,231 @@
+package urlshort
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Click is a redirect served by Handler. Key is the key of
+// the link that matched, which for wildcards and templates is
+// the pattern rather than the request path; Path is the path
+// that was requested.
+type Click struct {
+	Key       string    `json:"key"`
+	Host      string    `json:"host,omitempty"`
+	Path      string    `json:"path"`
+	URL       string    `json:"url"`
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// IP is the client address with the host part zeroed:
+	// the last octet of an IPv4 address, or everything after
+	// the first 48 bits of an IPv6 one.
+	IP string `json:"ip,omitempty"`
+}
+
+// A ClickSink receives a Click for every redirect. Record is
+// called on the request goroutine, so it must not block;
+// wrap sinks that do I/O in an AsyncSink.
+type ClickSink interface {
+	Record(c Click)
+}
+
+// ClickSinkFunc adapts an ordinary function to the ClickSink
+// interface.
+type ClickSinkFunc func(c Click)
+
+// Record calls f(c).
+func (f ClickSinkFunc) Record(c Click) { f(c) }
+
+// WithClickSink makes Handler report every redirect to sink.
+func WithClickSink(sink ClickSink) Option {
+	return func(o *options) {
+		o.clicks = sink
+	}
+}
+
+// newClick describes the redirect of r to dest for link.
+func newClick(r *http.Request, link Link, dest string) Click {
+	return Click{
+		Key:       link.Key(),
+		Host:      canonicalHost(r.Host),
+		Path:      r.URL.Path,
+		URL:       dest,
+		Time:      time.Now().UTC(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        anonymizeIP(r.RemoteAddr),
+	}
+}
+
+// anonymizeIP returns the address in addr (an IP with an
+// optional port) with its host part zeroed, or "" if addr
+// isn't an IP address.
+func anonymizeIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// AsyncSink passes clicks on to another sink from a
+// goroutine of its own, so that a slow sink never holds up a
+// redirect. Clicks that arrive while its buffer is full are
+// dropped and counted.
+type AsyncSink struct {
+	sink    ClickSink
+	clicks  chan Click
+	done    chan struct{}
+	dropped atomic.Int64
+
+	mu     sync.RWMutex // guards closing clicks
+	closed bool
+}
+
+// NewAsyncSink starts an AsyncSink buffering up to buffer
+// clicks for sink. Call Close to stop it.
+func NewAsyncSink(sink ClickSink, buffer int) *AsyncSink {
+	s := &AsyncSink{sink: sink, clicks: make(chan Click, buffer), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for c := range s.clicks {
+			s.sink.Record(c)
+		}
+	}()
+	return s
+}
+
+// Record queues c, or drops it if the buffer is full.
+// Clicks recorded after Close are dropped too.
+func (s *AsyncSink) Record(c Click) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Add(1)
+		return
+	}
+	select {
+	case s.clicks <- c:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of clicks dropped so far.
+func (s *AsyncSink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close stops accepting clicks and waits until the ones
+// already queued have been recorded.
+func (s *AsyncSink) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.clicks)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+// ClickCounter is a ClickSink that keeps the total and the
+// daily number of clicks (by UTC date) of every link in
+// memory. Admin serves them when its Clicks field is set. It
+// is safe for concurrent use.
+type ClickCounter struct {
+	mu    sync.Mutex
+	links map[string]*linkClicks
+}
+
+type linkClicks struct {
+	total int64
+	daily map[string]int64 // by date, as 2006-01-02
+	last  time.Time
+}
+
+// LinkClicks are the clicks counted for one link.
+type LinkClicks struct {
+	Key   string     `json:"key"`
+	Total int64      `json:"total"`
+	Last  time.Time  `json:"last"`
+	Daily []DayCount `json:"daily,omitempty"`
+}
+
+// DayCount is the number of clicks on one UTC date.
+type DayCount struct {
+	Date  string `json:"date"` // 2006-01-02
+	Count int64  `json:"count"`
+}
+
+// NewClickCounter returns an empty ClickCounter.
+func NewClickCounter() *ClickCounter {
+	return &ClickCounter{links: make(map[string]*linkClicks)}
+}
+
+// Record counts c.
+func (cc *ClickCounter) Record(c Click) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	l := cc.links[c.Key]
+	if l == nil {
+		l = &linkClicks{daily: make(map[string]int64)}
+		cc.links[c.Key] = l
+	}
+	l.total++
+	l.daily[c.Time.UTC().Format(time.DateOnly)]++
+	if c.Time.After(l.last) {
+		l.last = c.Time
+	}
+}
+
+// Totals returns the total clicks of every link that has been
+// clicked, most clicked first.
+func (cc *ClickCounter) Totals() []LinkClicks {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	totals := make([]LinkClicks, 0, len(cc.links))
+	for key, l := range cc.links {
+		totals = append(totals, LinkClicks{Key: key, Total: l.total, Last: l.last})
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Total != totals[j].Total {
+			return totals[i].Total > totals[j].Total
+		}
+		return totals[i].Key < totals[j].Key
+	})
+	return totals
+}
+
+// Link returns the clicks of the link with the given key, with
+// the daily counts since the start of the date of since, in
+// date order. Days without clicks are left out.
+func (cc *ClickCounter) Link(key string, since time.Time) LinkClicks {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	lc := LinkClicks{Key: key}
+	l := cc.links[key]
+	if l == nil {
+		return lc
+	}
+	lc.Total, lc.Last = l.total, l.last
+	from := since.UTC().Format(time.DateOnly)
+	for date, n := range l.daily {
+		if date >= from {
+			lc.Daily = append(lc.Daily, DayCount{Date: date, Count: n})
+		}
+	}
+	sort.Slice(lc.Daily, func(i, j int) bool { return lc.Daily[i].Date < lc.Daily[j].Date })
+	return lc
+}
@@ -0,0 +1,62 @@
+package urlshort
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClicks(t *testing.T) {
+	counter := NewClickCounter()
+	sink := NewAsyncSink(counter, 16)
+	var last Click
+	handler := MapHandler(map[string]string{"/a": "https://a.com", "/gh/*": "https://github.com/*"}, http.HandlerFunc(fallback),
+		WithClickSink(ClickSinkFunc(func(c Click) {
+			last = c
+			sink.Record(c)
+		})))
+
+	for _, target := range []string{"/a", "/a", "/gh/x", "/gh/y", "/gh/z", "/nope"} {
+		request := httptest.NewRequest("GET", target, nil)
+		request.RemoteAddr = "203.0.113.77:4321"
+		request.Header.Set("Referer", "https://news.example.com/")
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	sink.Close()
+	if last.Key != "/gh/*" || last.Path != "/gh/z" || last.URL != "https://github.com/z" ||
+		last.IP != "203.0.113.0" || last.Referrer != "https://news.example.com/" {
+		t.Errorf("Unexpected click %+v", last)
+	}
+	if sink.Dropped() != 0 {
+		t.Errorf("Expected no dropped clicks, got %d", sink.Dropped())
+	}
+
+	admin := NewAdmin(NewMapStore(nil))
+	admin.Clicks = counter
+	var totals []LinkClicks
+	json.NewDecoder(serve(admin, "/api/clicks").Body).Decode(&totals)
+	if len(totals) != 2 || totals[0].Key != "/gh/*" || totals[0].Total != 3 || totals[1].Total != 2 {
+		t.Errorf("Unexpected totals %+v", totals)
+	}
+	var a LinkClicks
+	json.NewDecoder(serve(admin, "/api/clicks/a?days=7").Body).Decode(&a)
+	today := time.Now().UTC().Format(time.DateOnly)
+	if a.Total != 2 || len(a.Daily) != 1 || a.Daily[0] != (DayCount{Date: today, Count: 2}) {
+		t.Errorf("Unexpected clicks for /a %+v", a)
+	}
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.123:80":        "192.0.2.0",
+		"[2001:db8:1:2::7]:443": "2001:db8:1::",
+		"2001:db8:abcd:12::1":   "2001:db8:abcd::",
+		"not an ip":             "",
+	} {
+		if got := anonymizeIP(addr); got != want {
+			t.Errorf("anonymizeIP(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
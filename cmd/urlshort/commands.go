@@ -0,0 +1,775 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gophercises/urlshort"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var serveCmd = &command{
+	name:  "serve",
+	short: "Serve redirects for the links in the store",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		serveFlags.addr = fs.String("addr", ":8080", "address to listen on")
+		serveFlags.admin = fs.Bool("admin", false, "serve the admin API, including click counts, at /api/")
+		serveFlags.trustProxyUser = fs.Bool("trust-proxy-user", false, "take the admin API user from the X-Forwarded-User header of an authenticating proxy")
+		serveFlags.codes = fs.String("codes", "random:7", "how the admin API generates short codes: random, hash or sequential, optionally with :length or :start")
+		serveFlags.reload = fs.Duration("reload", 2*time.Second, "how often to check a link file for changes")
+		serveFlags.cache = fs.Int("cache", 0, "cache up to this many lookups in memory (0 disables the cache)")
+		serveFlags.cacheTTL = fs.Duration("cache-ttl", time.Minute, "how long to cache links and misses for")
+		serveFlags.metrics = fs.Bool("metrics", false, "serve Prometheus metrics at /metrics")
+		serveFlags.expiryURL = fs.String("expiry-url", "", "redirect expired links here instead of answering 410 Gone")
+		serveFlags.purgeAfter = fs.Duration("purge-after", 0, "permanently remove tombstones this old, checking hourly (0 keeps them)")
+		serveFlags.accessLog = fs.String("access-log", "", "log every request to stderr as text or json")
+		serveFlags.sampleInitial = fs.Int("log-sample-initial", 0, "with -access-log, log only the first N requests per link each second (0 logs all)")
+		serveFlags.sampleThereafter = fs.Int("log-sample-thereafter", 0, "with -access-log, also log every Nth request past the first N each second")
+	},
+	run: serve,
+}
+
+var serveFlags struct {
+	addr   *string
+	admin  *bool
+	codes  *string
+	reload *time.Duration
+
+	trustProxyUser *bool
+
+	cache    *int
+	cacheTTL *time.Duration
+
+	metrics    *bool
+	expiryURL  *string
+	purgeAfter *time.Duration
+
+	accessLog        *string
+	sampleInitial    *int
+	sampleThereafter *int
+}
+
+func serve(ctx context.Context, c *cli, args []string) error {
+	if len(args) > 0 {
+		return usageError("serve takes no arguments")
+	}
+	var logHandler slog.Handler
+	switch *serveFlags.accessLog {
+	case "":
+	case "text":
+		logHandler = slog.NewTextHandler(c.stderr, nil)
+	case "json":
+		logHandler = slog.NewJSONHandler(c.stderr, nil)
+	default:
+		return usageError(fmt.Sprintf("unknown -access-log format %q (want text or json)", *serveFlags.accessLog))
+	}
+	codes, err := urlshort.ParseCodeStrategy(*serveFlags.codes)
+	if err != nil {
+		return usageError(strings.TrimPrefix(err.Error(), "urlshort: "))
+	}
+	var (
+		reg     *prometheus.Registry
+		metrics *urlshort.Metrics
+	)
+	if *serveFlags.metrics {
+		reg = prometheus.NewRegistry()
+		reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		metrics = urlshort.NewMetrics(reg)
+	}
+	audit, err := c.openAudit()
+	if err != nil {
+		return err
+	}
+	if audit != nil {
+		defer audit.Close()
+	}
+	s, closeStore, err := openStore(c.store, false, urlshort.WithMetrics(metrics), urlshort.WithAuditLog(audit))
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	if file, ok := s.(*urlshort.FileStore); ok {
+		go file.Watch(ctx, *serveFlags.reload)
+	}
+	history, err := c.openHistory(c.store)
+	if err != nil {
+		return err
+	}
+	if history != nil {
+		defer history.Close()
+	}
+	s = recording(s, history, audit)
+	if *serveFlags.purgeAfter > 0 {
+		purger := &urlshort.Purger{Store: s, MaxAge: *serveFlags.purgeAfter}
+		go purger.Run(ctx, time.Hour)
+	}
+	// Time the store itself, not the cache in front of it.
+	s = metrics.InstrumentStore(c.store, s).(store)
+	if *serveFlags.cache > 0 {
+		s = urlshort.NewCachedStore(s, *serveFlags.cache, *serveFlags.cacheTTL, *serveFlags.cacheTTL)
+	}
+
+	mux := http.NewServeMux()
+	opts := []urlshort.Option{urlshort.WithMetrics(metrics)}
+	if *serveFlags.expiryURL != "" {
+		u, err := url.Parse(*serveFlags.expiryURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return usageError(fmt.Sprintf("-expiry-url %q is not an absolute http(s) URL", *serveFlags.expiryURL))
+		}
+		opts = append(opts, urlshort.WithExpiryURL(*serveFlags.expiryURL))
+	}
+	if *serveFlags.admin {
+		// Count clicks for the admin API to report.
+		clicks := urlshort.NewClickCounter()
+		sink := urlshort.NewAsyncSink(clicks, 1024)
+		defer sink.Close()
+		admin := urlshort.NewAdmin(s)
+		admin.Clicks = clicks
+		admin.Shortener = &urlshort.Shortener{Codes: codes}
+		admin.TrustProxyUser = *serveFlags.trustProxyUser
+		if history != nil {
+			admin.History = history
+		}
+		admin.Audit = audit
+		mux.Handle("/api/", admin)
+		opts = append(opts, urlshort.WithClickSink(sink))
+	}
+	if reg != nil {
+		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	}
+	var redirects http.Handler = urlshort.Handler(s, http.NotFoundHandler(), opts...)
+	if logHandler != nil {
+		accessLog := &urlshort.AccessLog{
+			Logger: slog.New(logHandler),
+			Sampling: urlshort.Sampling{
+				Initial:    *serveFlags.sampleInitial,
+				Thereafter: *serveFlags.sampleThereafter,
+			},
+		}
+		redirects = accessLog.Wrap(redirects)
+	}
+	mux.Handle("/", redirects)
+	srv := &http.Server{Addr: *serveFlags.addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	fmt.Fprintf(c.stderr, "urlshort: serving %s on %s\n", c.store, *serveFlags.addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+var addCmd = &command{
+	name:  "add",
+	args:  "path url",
+	short: "Add a link, or replace it with -force",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		linkFlags.host = fs.String("host", "", "only redirect requests for this host")
+		linkFlags.status = fs.Int("status", 0, "redirect status code (default 302)")
+		linkFlags.query = fs.String("query", "", "query string policy: drop, append, merge-incoming or merge-destination")
+		linkFlags.force = fs.Bool("force", false, "replace an existing link for the path")
+		linkFlags.notBefore = fs.String("not-before", "", "only redirect from this time on (RFC 3339 timestamp or date)")
+		linkFlags.expires = fs.String("expires", "", "answer 410 Gone from this time on (RFC 3339 timestamp or date)")
+	},
+	run: add,
+}
+
+var linkFlags struct {
+	host   *string
+	status *int
+	query  *string
+	force  *bool
+
+	notBefore *string
+	expires   *string
+}
+
+func add(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 2 {
+		return usageError("add needs a path and a URL")
+	}
+	s, _, closeStore, err := c.openRecorded(true)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	link := urlshort.Link{
+		Host:   *linkFlags.host,
+		Path:   args[0],
+		URL:    args[1],
+		Status: *linkFlags.status,
+		Query:  urlshort.QueryPolicy(*linkFlags.query),
+	}
+	for _, f := range []struct {
+		dst  **time.Time
+		flag string
+	}{{&link.NotBefore, *linkFlags.notBefore}, {&link.ExpiresAt, *linkFlags.expires}} {
+		if f.flag == "" {
+			continue
+		}
+		t, err := urlshort.ParseLinkTime(f.flag)
+		if err != nil {
+			return usageError(err.Error())
+		}
+		*f.dst = &t
+	}
+	if !*linkFlags.force {
+		existing, err := s.Lookup(ctx, link.Key())
+		switch {
+		case err == nil && existing.Key() == link.Key() && !existing.Deleted():
+			return fmt.Errorf("%w: %s already exists (use -force to replace it)", urlshort.ErrConflict, link.Key())
+		case err != nil && !errors.Is(err, urlshort.ErrNotFound):
+			return err
+		}
+	}
+	if err := s.Put(ctx, link); err != nil {
+		return err
+	}
+	c.output(link, func(w io.Writer) {
+		fmt.Fprintf(w, "%s -> %s\n", link.Key(), link.URL)
+	})
+	return closeStore()
+}
+
+var rmCmd = &command{
+	name:  "rm",
+	args:  "path...",
+	short: "Remove links, leaving tombstones that answer 410 Gone",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		rmFlags.host = fs.String("host", "", "remove the links scoped to this host")
+		rmFlags.by = fs.String("by", os.Getenv("USER"), "who is removing the links")
+		rmFlags.reason = fs.String("reason", "", "why the links are being removed, shown on the 410 page")
+		rmFlags.purge = fs.Bool("purge", false, "remove the links for good rather than leaving tombstones")
+		rmFlags.match = fs.Bool("match", false, "the arguments are the patterns of match rules to remove, which are always removed for good")
+	},
+	run: rm,
+}
+
+var rmFlags struct {
+	host   *string
+	by     *string
+	reason *string
+	purge  *bool
+	match  *bool
+}
+
+func rm(ctx context.Context, c *cli, args []string) error {
+	if len(args) == 0 {
+		return usageError("rm needs at least one path")
+	}
+	ctx = urlshort.ContextWithActor(ctx, *rmFlags.by)
+	s, _, closeStore, err := c.openRecorded(false)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	removed := []string{}
+	for _, path := range args {
+		key := urlshort.Link{Host: *rmFlags.host, Path: path}.Key()
+		switch {
+		case *rmFlags.match:
+			key = path
+			err = urlshort.DeleteRule(ctx, s, *rmFlags.host, path)
+		case *rmFlags.purge:
+			err = s.Delete(ctx, key)
+		default:
+			_, err = urlshort.SoftDelete(ctx, s, key, *rmFlags.by, *rmFlags.reason)
+		}
+		if err != nil {
+			return err
+		}
+		removed = append(removed, key)
+	}
+	c.output(removed, func(w io.Writer) {
+		for _, key := range removed {
+			fmt.Fprintf(w, "removed %s\n", key)
+		}
+	})
+	return closeStore()
+}
+
+var restoreCmd = &command{
+	name:  "restore",
+	args:  "path...",
+	short: "Restore removed links from their tombstones",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		restoreFlags.host = fs.String("host", "", "restore the links scoped to this host")
+	},
+	run: restore,
+}
+
+var restoreFlags struct {
+	host *string
+}
+
+func restore(ctx context.Context, c *cli, args []string) error {
+	if len(args) == 0 {
+		return usageError("restore needs at least one path")
+	}
+	s, _, closeStore, err := c.openRecorded(false)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	restored := []urlshort.Link{}
+	for _, path := range args {
+		link, err := urlshort.Restore(ctx, s, urlshort.Link{Host: *restoreFlags.host, Path: path}.Key())
+		if err != nil {
+			return err
+		}
+		restored = append(restored, link)
+	}
+	c.output(restored, func(w io.Writer) {
+		for _, link := range restored {
+			fmt.Fprintf(w, "restored %s -> %s\n", link.Key(), link.URL)
+		}
+	})
+	return closeStore()
+}
+
+var purgeCmd = &command{
+	name:  "purge",
+	short: "Permanently remove tombstones",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		purgeFlags.olderThan = fs.Duration("older-than", 0, "only remove tombstones older than this")
+	},
+	run: purge,
+}
+
+var purgeFlags struct {
+	olderThan *time.Duration
+}
+
+func purge(ctx context.Context, c *cli, args []string) error {
+	if len(args) > 0 {
+		return usageError("purge takes no arguments")
+	}
+	s, audit, closeStore, err := c.openRecorded(false)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	purged, err := urlshort.PurgeTombstones(ctx, s, time.Now().Add(-*purgeFlags.olderThan))
+	if err != nil {
+		return err
+	}
+	if len(purged) > 0 {
+		detail := "purged tombstones"
+		if *purgeFlags.olderThan > 0 {
+			detail += " older than " + purgeFlags.olderThan.String()
+		}
+		if err := audit.Record(ctx, urlshort.AuditEvent{Action: urlshort.AuditBulk, Links: len(purged), Detail: detail}); err != nil {
+			return err
+		}
+	}
+	if purged == nil {
+		purged = []urlshort.Link{}
+	}
+	c.output(purged, func(w io.Writer) {
+		for _, link := range purged {
+			fmt.Fprintf(w, "purged %s\n", link.Key())
+		}
+	})
+	return closeStore()
+}
+
+var historyCmd = &command{
+	name:  "history",
+	args:  "path",
+	short: "List the revisions of a link",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		historyFlags.host = fs.String("host", "", "list the revisions of the link scoped to this host")
+	},
+	run: listHistory,
+}
+
+var historyFlags struct {
+	host *string
+}
+
+func listHistory(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return usageError("history needs a path")
+	}
+	h, err := c.openHistory(c.store)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return errors.New("no history is recorded with -history off")
+	}
+	defer h.Close()
+
+	key := urlshort.Link{Host: *historyFlags.host, Path: args[0]}.Key()
+	revs, err := h.Revisions(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(revs) == 0 {
+		return fmt.Errorf("%w: no history for %s", urlshort.ErrNotFound, key)
+	}
+	c.output(revs, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tTIME\tAUTHOR\tCHANGE\tURL")
+		for _, rev := range revs {
+			change := string(rev.Op)
+			if rev.Op == urlshort.OpRollback {
+				change = fmt.Sprintf("rollback to %d", rev.RolledBackTo)
+			}
+			dest := rev.NewURL()
+			if rev.Old != nil && rev.OldURL() != rev.NewURL() {
+				dest = rev.OldURL() + " -> " + rev.NewURL()
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", rev.Version, rev.Time.Local().Format(time.DateTime), rev.Author, change, dest)
+		}
+		tw.Flush()
+	})
+	return nil
+}
+
+var rollbackCmd = &command{
+	name:  "rollback",
+	args:  "path",
+	short: "Roll a link back to an earlier revision",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		rollbackFlags.host = fs.String("host", "", "roll back the link scoped to this host")
+		rollbackFlags.version = fs.Int("version", 0, "the version to go back to, as listed by history")
+	},
+	run: rollback,
+}
+
+var rollbackFlags struct {
+	host    *string
+	version *int
+}
+
+func rollback(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return usageError("rollback needs a path")
+	}
+	if *rollbackFlags.version < 1 {
+		return usageError("rollback needs a -version")
+	}
+	s, _, closeStore, err := c.openRecorded(false)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	// The store records the rollback in the same file.
+	h, err := c.openHistory(c.store)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return errors.New("no history is recorded with -history off")
+	}
+	defer h.Close()
+
+	key := urlshort.Link{Host: *rollbackFlags.host, Path: args[0]}.Key()
+	link, err := urlshort.Rollback(ctx, s, h, key, *rollbackFlags.version)
+	if err != nil {
+		return err
+	}
+	c.output(link, func(w io.Writer) {
+		if link.URL == "" {
+			fmt.Fprintf(w, "rolled %s back to version %d: removed\n", key, *rollbackFlags.version)
+			return
+		}
+		state := link.URL
+		if link.Deleted() {
+			state += " (deleted)"
+		}
+		fmt.Fprintf(w, "rolled %s back to version %d: %s\n", key, *rollbackFlags.version, state)
+	})
+	return closeStore()
+}
+
+var lsCmd = &command{
+	name:  "ls",
+	short: "List links",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		lsFlags.host = fs.String("host", "", "only list the links scoped to this host")
+		lsFlags.deleted = fs.Bool("deleted", false, "also list tombstones of removed links")
+	},
+	run: ls,
+}
+
+var lsFlags struct {
+	host    *string
+	deleted *bool
+}
+
+func ls(ctx context.Context, c *cli, args []string) error {
+	if len(args) > 0 {
+		return usageError("ls takes no arguments")
+	}
+	s, closeStore, err := openStore(c.store, false)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	all, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	links := []urlshort.Link{}
+	for _, link := range all {
+		if link.Deleted() && !*lsFlags.deleted {
+			continue
+		}
+		if *lsFlags.host == "" || strings.EqualFold(link.Host, *lsFlags.host) {
+			links = append(links, link)
+		}
+	}
+	c.output(links, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "PATH\tURL\tSTATUS\tACTIVE")
+		for _, link := range links {
+			key := link.Key()
+			if link.Match != "" {
+				key = link.Host + "~" + link.Match
+			}
+			status := "-"
+			if link.Status != 0 {
+				status = fmt.Sprint(link.Status)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key, link.URL, status, window(link))
+		}
+		tw.Flush()
+	})
+	return nil
+}
+
+// window describes when link is active.
+func window(link urlshort.Link) string {
+	switch {
+	case link.Deleted():
+		return "deleted " + link.DeletedAt.Format(time.RFC3339) + " by " + link.DeletedBy
+	case link.NotBefore != nil && link.ExpiresAt != nil:
+		return link.NotBefore.Format(time.RFC3339) + " to " + link.ExpiresAt.Format(time.RFC3339)
+	case link.NotBefore != nil:
+		return "from " + link.NotBefore.Format(time.RFC3339)
+	case link.ExpiresAt != nil:
+		return "until " + link.ExpiresAt.Format(time.RFC3339)
+	}
+	return "-"
+}
+
+var resolveCmd = &command{
+	name:  "resolve",
+	args:  "path|url",
+	short: "Show where a path or URL redirects to",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		resolveFlags.host = fs.String("host", "", "resolve as a request for this host")
+	},
+	run: resolve,
+}
+
+var resolveFlags struct {
+	host *string
+}
+
+type resolution struct {
+	Link    urlshort.Link `json:"link"`
+	URL     string        `json:"url"`
+	Status  int           `json:"status"`
+	Expired bool          `json:"expired,omitempty"`
+	Deleted bool          `json:"deleted,omitempty"`
+}
+
+func resolve(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return usageError("resolve needs one path or URL")
+	}
+	target, err := url.Parse(args[0])
+	if err != nil || (target.Host == "" && !strings.HasPrefix(target.Path, "/")) {
+		return usageError(fmt.Sprintf("%q is not a path or absolute URL", args[0]))
+	}
+	s, closeStore, err := openStore(c.store, false)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, target.RequestURI(), nil)
+	if err != nil {
+		return err
+	}
+	r.Host = target.Host
+	if *resolveFlags.host != "" {
+		r.Host = *resolveFlags.host
+	}
+	link, dest, status, err := urlshort.Resolve(s, r)
+	expired, deleted := errors.Is(err, urlshort.ErrExpired), errors.Is(err, urlshort.ErrDeleted)
+	if errors.Is(err, urlshort.ErrNotFound) {
+		return fmt.Errorf("no link for %s", args[0])
+	}
+	if err != nil && !expired && !deleted {
+		return err
+	}
+	res := resolution{Link: link, URL: dest, Status: status, Expired: expired, Deleted: deleted}
+	c.output(res, func(w io.Writer) {
+		if res.Deleted {
+			fmt.Fprintf(w, "%d %s\n", res.Status, window(res.Link))
+			return
+		}
+		if res.Expired {
+			fmt.Fprintf(w, "%d expired at %s\n", res.Status, res.Link.ExpiresAt.Format(time.RFC3339))
+			return
+		}
+		fmt.Fprintf(w, "%d %s\n", res.Status, res.URL)
+	})
+	return nil
+}
+
+var importCmd = &command{
+	name:  "import",
+	args:  "file...",
+	short: "Add the links from link files",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		importFlags.replace = fs.Bool("replace", false, "also remove the links that aren't in the imported files, leaving tombstones")
+	},
+	run: importFiles,
+}
+
+var importFlags struct {
+	replace *bool
+}
+
+type importResult struct {
+	Imported int `json:"imported"`
+	Removed  int `json:"removed"`
+}
+
+func importFiles(ctx context.Context, c *cli, args []string) error {
+	if len(args) == 0 {
+		return usageError("import needs at least one file")
+	}
+	// Load everything first, so an invalid file doesn't leave
+	// the store half imported.
+	var links []urlshort.Link
+	for _, path := range args {
+		l, err := urlshort.LoadFile(path)
+		if err != nil {
+			return err
+		}
+		links = append(links, l...)
+	}
+	s, audit, closeStore, err := c.openRecorded(true)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	res := importResult{Imported: len(links)}
+	if *importFlags.replace {
+		// Links that aren't imported become tombstones, as with
+		// rm, in the same PutAll as the imported ones.
+		imported := make(map[string]bool)
+		for _, link := range links {
+			imported[link.Key()+"~"+link.Match] = true
+		}
+		existing, err := s.List(ctx)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, link := range existing {
+			if imported[link.Key()+"~"+link.Match] || link.Match != "" || link.Deleted() {
+				continue
+			}
+			link.DeletedAt, link.DeletedBy, link.DeleteReason = &now, urlshort.ActorFromContext(ctx), "replaced by import"
+			links = append(links, link)
+			res.Removed++
+		}
+	}
+	if err := urlshort.PutAll(ctx, s, links); err != nil {
+		return err
+	}
+	ev := urlshort.AuditEvent{Action: urlshort.AuditImport, Source: strings.Join(args, ", "), Links: res.Imported}
+	if *importFlags.replace {
+		ev.Detail = fmt.Sprintf("replaced the store, removing %d links", res.Removed)
+	}
+	if err := audit.Record(ctx, ev); err != nil {
+		return err
+	}
+	c.output(res, func(w io.Writer) {
+		fmt.Fprintf(w, "imported %d links, removed %d\n", res.Imported, res.Removed)
+	})
+	return closeStore()
+}
+
+var exportCmd = &command{
+	name:  "export",
+	short: "Write every link to standard output or a file",
+	flags: func(fs *flag.FlagSet, c *cli) {
+		exportFlags.format = fs.String("format", "", "output `format`: "+strings.Join(urlshort.Formats(), ", ")+" (default yaml, or picked by the -o extension)")
+		exportFlags.output = fs.String("o", "", "write to `file` instead of standard output")
+	},
+	run: export,
+}
+
+var exportFlags struct {
+	format *string
+	output *string
+}
+
+func export(ctx context.Context, c *cli, args []string) error {
+	if len(args) > 0 {
+		return usageError("export takes no arguments")
+	}
+	format := *exportFlags.format
+	if c.json && format == "" {
+		format = "json"
+	}
+	s, closeStore, err := openStore(c.store, false)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	links, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	if *exportFlags.output != "" && format == "" {
+		return urlshort.SaveFile(*exportFlags.output, links)
+	}
+	if format == "" {
+		format = "yaml"
+	}
+	data, err := urlshort.EncodeLinks(format, links)
+	if err != nil {
+		return err
+	}
+	if *exportFlags.output != "" {
+		return os.WriteFile(*exportFlags.output, data, 0o644)
+	}
+	_, err = c.stdout.Write(data)
+	return err
+}
@@ -0,0 +1,205 @@
+// Command urlshort manages and serves the links in a link
+// store.
+//
+// Usage:
+//
+//     urlshort <command> [flags] [arguments]
+//
+// The commands are:
+//
+//     serve     serve redirects (and optionally the admin API)
+//     add       add or replace a link
+//     rm        remove links, leaving tombstones
+//     restore   restore removed links
+//     purge     permanently remove old tombstones
+//     history   list the revisions of a link
+//     rollback  roll a link back to an earlier revision
+//     ls        list links
+//     resolve   show where a path or URL redirects to
+//     import    add the links from link files
+//     export    write every link in a given format
+//
+// Every command takes the same -store, -history, -audit and
+// -json flags. The store defaults to $URLSHORT_STORE, or
+// links.yaml if that is unset; see openStore for the kinds of
+// store supported. Changes to links are recorded in the
+// history file next to the store (see openHistory), and in the
+// audit log given by -audit or $URLSHORT_AUDIT, if any. With
+// -json, results are written to standard output as JSON and
+// errors to standard error as {"error": "...", "problems":
+// [...]}.
+//
+// The exit status is 0 on success, 1 if the command failed
+// (including a link that wasn't found) and 2 for usage errors.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gophercises/urlshort"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// A command is one urlshort subcommand. run is called with the
+// flags parsed and returns the error to report, if any.
+type command struct {
+	name  string
+	args  string
+	short string
+	flags func(fs *flag.FlagSet, c *cli)
+	run   func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = []*command{
+	serveCmd,
+	addCmd,
+	rmCmd,
+	restoreCmd,
+	purgeCmd,
+	historyCmd,
+	rollbackCmd,
+	lsCmd,
+	resolveCmd,
+	importCmd,
+	exportCmd,
+}
+
+// cli holds the flags shared by every command and where output
+// goes.
+type cli struct {
+	store   string
+	history string
+	audit   string
+	json    bool
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// usageError is returned by a command whose arguments are
+// wrong; it makes main print the usage and exit with 2.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+	var cmd *command
+	for _, c := range commands {
+		if c.name == args[0] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "urlshort: unknown command %q\n\n", args[0])
+		usage(stderr)
+		return exitUsage
+	}
+
+	c := &cli{stdout: stdout, stderr: stderr}
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: urlshort %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, cmd.short)
+		fs.PrintDefaults()
+	}
+	defaultStore := os.Getenv("URLSHORT_STORE")
+	if defaultStore == "" {
+		defaultStore = "links.yaml"
+	}
+	fs.StringVar(&c.store, "store", defaultStore, "link store to use: a link file, or a `spec` as described in the documentation")
+	fs.StringVar(&c.history, "history", os.Getenv("URLSHORT_HISTORY"), "`file` to record the revisions of links in (default the store's path plus .history, or $URLSHORT_HISTORY; \"off\" records none)")
+	fs.StringVar(&c.audit, "audit", os.Getenv("URLSHORT_AUDIT"), "`file` to append an audit log of changes to, rotated at 100MB")
+	fs.BoolVar(&c.json, "json", false, "write machine-readable JSON output")
+	if cmd.flags != nil {
+		cmd.flags(fs, c)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	// Changes are recorded as made by the user running us.
+	ctx = urlshort.ContextWithActor(ctx, os.Getenv("USER"))
+	err := cmd.run(ctx, c, fs.Args())
+	var uerr usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &uerr):
+		fmt.Fprintf(stderr, "urlshort %s: %v\n", cmd.name, err)
+		fs.Usage()
+		return exitUsage
+	default:
+		c.fail(err)
+		return exitError
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: urlshort <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.short)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "urlshort <command> -h" for the flags of a command.`)
+}
+
+// output writes v as JSON with -json, and otherwise calls text
+// to write it for people.
+func (c *cli) output(v interface{}, text func(w io.Writer)) {
+	if c.json {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+	text(c.stdout)
+}
+
+type jsonError struct {
+	Error    string   `json:"error"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// fail reports err on standard error, listing each invalid
+// link separately.
+func (c *cli) fail(err error) {
+	var errs urlshort.LinkErrors
+	if !c.json {
+		fmt.Fprintf(c.stderr, "urlshort: %v\n", strings.TrimPrefix(err.Error(), "urlshort: "))
+		return
+	}
+	body := jsonError{Error: err.Error()}
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			body.Problems = append(body.Problems, e.Error())
+		}
+	}
+	json.NewEncoder(c.stderr).Encode(body)
+}
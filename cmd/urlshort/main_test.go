@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophercises/urlshort"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "links.yaml")
+	other := filepath.Join(dir, "other.yaml")
+	if err := os.WriteFile(other, []byte("- path: /b\n  url: https://b.com\n- path: /c\n  url: https://c.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules := filepath.Join(dir, "rules.yaml")
+	if err := os.WriteFile(rules, []byte("- match: ^/v(\\d+)$\n  url: https://example.com/version/$1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("URLSHORT_STORE", store)
+	t.Setenv("URLSHORT_HISTORY", "")
+	t.Setenv("URLSHORT_AUDIT", "")
+	t.Setenv("USER", "alice")
+
+	// The steps run in order against the same store.
+	steps := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string // a substring of the output, if not empty
+		stderr string // a substring of the errors, if not empty
+	}{
+		{"no command", nil, exitUsage, "", "usage: urlshort"},
+		{"help", []string{"help"}, exitOK, "", "Commands:"},
+		{"unknown command", []string{"frob"}, exitUsage, "", `unknown command "frob"`},
+		{"unknown flag", []string{"ls", "-nope"}, exitUsage, "", "flag provided but not defined"},
+		{"missing arguments", []string{"add", "/a"}, exitUsage, "", "usage: urlshort add"},
+		{"add", []string{"add", "/a", "https://a.com"}, exitOK, "/a -> https://a.com", ""},
+		{"add json", []string{"add", "-json", "-force", "/a", "https://a.org"}, exitOK, `"url": "https://a.org"`, ""},
+		{"invalid link", []string{"add", "/d", "javascript:alert(1)"}, exitError, "", "urlshort: "},
+		{"invalid link json", []string{"add", "-json", "/d", "javascript:alert(1)"}, exitError, "", `"problems":[`},
+		{"missing link json", []string{"rm", "-json", "/nope"}, exitError, "", `"error":"`},
+		{"ls json", []string{"ls", "-json"}, exitOK, `"path": "/a"`, ""},
+		{"import", []string{"import", "-replace", other}, exitOK, "imported 2 links, removed 1", ""},
+		{"import json", []string{"import", "-json", other}, exitOK, `"imported": 2`, ""},
+		{"import a missing file", []string{"import", filepath.Join(dir, "missing.yaml")}, exitError, "", "missing.yaml"},
+		{"history", []string{"history", "/a"}, exitOK, "delete", ""},
+		{"import a rule", []string{"import", rules}, exitOK, "imported 1 links", ""},
+		{"rm a rule", []string{"rm", "-match", `^/v(\d+)$`}, exitOK, `removed ^/v(\d+)$`, ""},
+		{"rm a missing rule", []string{"rm", "-match", `^/v(\d+)$`}, exitError, "", "not found"},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), step.args, &stdout, &stderr)
+			if code != step.code {
+				t.Errorf("Expected exit code %d, got %d: %s", step.code, code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), step.stdout) {
+				t.Errorf("Expected the output to contain %q, got %q", step.stdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), step.stderr) {
+				t.Errorf("Expected the errors to contain %q, got %q", step.stderr, stderr.String())
+			}
+		})
+	}
+
+	t.Run("it reports invalid links as JSON", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		if code := run(context.Background(), []string{"add", "-json", "/d", "ftp://d.com"}, &stdout, &stderr); code != exitError {
+			t.Fatalf("Expected exit code %d, got %d", exitError, code)
+		}
+		var body jsonError
+		if err := json.Unmarshal(stderr.Bytes(), &body); err != nil {
+			t.Fatalf("Expected a JSON error, got %q: %v", stderr.String(), err)
+		}
+		if body.Error == "" || len(body.Problems) != 1 {
+			t.Errorf("Expected an error with one problem, got %+v", body)
+		}
+	})
+
+	t.Run("it leaves tombstones for links replaced by an import", func(t *testing.T) {
+		links, err := urlshort.LoadFile(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tombstones []urlshort.Link
+		for _, link := range links {
+			if link.Deleted() {
+				tombstones = append(tombstones, link)
+			}
+		}
+		if len(links) != 3 || len(tombstones) != 1 || tombstones[0].Path != "/a" || tombstones[0].DeletedBy != "alice" {
+			t.Errorf("Expected /b, /c and a tombstone for /a deleted by alice, got %v", links)
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/gophercises/urlshort"
+)
+
+// store is what the commands need from a link store.
+type store interface {
+	urlshort.MutableStore
+	urlshort.Lister
+}
+
+// openStore opens the store described by spec, which is a
+// kind, a colon and its argument, or just a path to a link
+// file:
+//
+//     links.yaml         a link file in any supported format
+//     file:links.json    the same, spelled out
+//     bolt:links.db      a Bolt database (see urlshort.BoltStore)
+//     sqlite:links.db    a SQLite database (see urlshort.SQLiteStore)
+//
+// If create is set, a missing link file is created empty. The
+// options are passed on to the store. The returned function
+// closes the store.
+func openStore(spec string, create bool, opts ...urlshort.Option) (store, func() error, error) {
+	kind, arg := parseSpec(spec)
+	switch kind {
+	case "file":
+		s, err := openFileStore(arg, create, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() error { return nil }, nil
+	case "bolt":
+		s, err := urlshort.OpenBoltStore(arg, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	case "sqlite":
+		s, err := urlshort.OpenSQLiteStore(arg, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	}
+	return nil, nil, fmt.Errorf("unknown store kind %q in %q", kind, spec)
+}
+
+// parseSpec splits a store spec into its kind and argument.
+func parseSpec(spec string) (kind, arg string) {
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok || len(kind) == 1 { // no kind, or a Windows drive letter
+		kind, arg = "file", spec
+	}
+	return kind, arg
+}
+
+// openHistory opens the file recording the revisions of the
+// links in the store described by spec: the -history flag if
+// set, or else the store's path plus .history, such as
+// links.yaml.history. It returns nil if -history is "off".
+func (c *cli) openHistory(spec string) (*urlshort.FileHistory, error) {
+	path := c.history
+	switch path {
+	case "off":
+		return nil, nil
+	case "":
+		_, arg := parseSpec(spec)
+		path = arg + ".history"
+	}
+	return urlshort.OpenFileHistory(path)
+}
+
+// auditMaxSize is the size at which the audit log is rotated.
+const auditMaxSize = 100 << 20
+
+// openAudit opens the audit log named by -audit, or returns
+// nil if there is none.
+func (c *cli) openAudit() (*urlshort.AuditLog, error) {
+	if c.audit == "" {
+		return nil, nil
+	}
+	return urlshort.OpenAuditLog(c.audit, auditMaxSize)
+}
+
+// openRecorded is like openStore, but records the changes made
+// to the store in its history and the audit log, with the
+// author set by the context. It also returns the audit log,
+// which is nil without -audit.
+func (c *cli) openRecorded(create bool) (store, *urlshort.AuditLog, func() error, error) {
+	s, closeStore, err := openStore(c.store, create)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	closers := []func() error{closeStore}
+	closeAll := func() error {
+		var err error
+		for i := len(closers) - 1; i >= 0; i-- {
+			if cerr := closers[i](); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}
+	history, err := c.openHistory(c.store)
+	if err != nil {
+		closeAll()
+		return nil, nil, nil, err
+	}
+	if history != nil {
+		closers = append(closers, history.Close)
+	}
+	audit, err := c.openAudit()
+	if err != nil {
+		closeAll()
+		return nil, nil, nil, err
+	}
+	if audit != nil {
+		closers = append(closers, audit.Close)
+	}
+	return recording(s, history, audit), audit, closeAll, nil
+}
+
+// recording wraps s in a RecordingStore if there is a history
+// or an audit log to record its changes in.
+func recording(s store, history *urlshort.FileHistory, audit *urlshort.AuditLog) store {
+	if history == nil && audit == nil {
+		return s
+	}
+	var h urlshort.History
+	if history != nil {
+		h = history
+	}
+	return urlshort.NewRecordingStore(s, h, audit)
+}
+
+func openFileStore(path string, create bool, opts ...urlshort.Option) (*urlshort.FileStore, error) {
+	if create {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			if err := urlshort.SaveFile(path, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return urlshort.NewFileStore(path, opts...)
+}
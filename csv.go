@@ -0,0 +1,158 @@
+package urlshort
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSV link files start with a header row naming the columns,
+// which may come in any order. path (or match) and url are
+// required; host, status, query, not_before, expires_at and
+// the tombstone columns deleted_at, deleted_by and
+// delete_reason are optional:
+//
+//     path,url,status
+//     /a,https://a.example.com,
+//     /b,https://b.example.com,301
+
+var csvColumns = map[string]func(*Link, string) error{
+	"host":          func(l *Link, v string) error { l.Host = v; return nil },
+	"path":          func(l *Link, v string) error { l.Path = v; return nil },
+	"match":         func(l *Link, v string) error { l.Match = v; return nil },
+	"url":           func(l *Link, v string) error { l.URL = v; return nil },
+	"query":         func(l *Link, v string) error { l.Query = QueryPolicy(v); return nil },
+	"not_before":    func(l *Link, v string) error { return parseCSVTime(&l.NotBefore, v) },
+	"expires_at":    func(l *Link, v string) error { return parseCSVTime(&l.ExpiresAt, v) },
+	"deleted_at":    func(l *Link, v string) error { return parseCSVTime(&l.DeletedAt, v) },
+	"deleted_by":    func(l *Link, v string) error { l.DeletedBy = v; return nil },
+	"delete_reason": func(l *Link, v string) error { l.DeleteReason = v; return nil },
+	"status": func(l *Link, v string) error {
+		if v == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid status %q", v)
+		}
+		l.Status = n
+		return nil
+	},
+}
+
+func init() {
+	RegisterFormat(Format{
+		Name:       "csv",
+		Extensions: []string{".csv"},
+		Sniff: func(data []byte) bool {
+			header, _, _ := bytes.Cut(bytes.TrimLeft(data, "\ufeff"), []byte("\n"))
+			cols := strings.Split(strings.TrimSpace(string(header)), ",")
+			hasURL := false
+			for _, col := range cols {
+				if _, ok := csvColumns[strings.TrimSpace(col)]; !ok {
+					return false
+				}
+				hasURL = hasURL || strings.TrimSpace(col) == "url"
+			}
+			return len(cols) > 1 && hasURL
+		},
+		Decode: parseCSV,
+		Encode: encodeCSV,
+	})
+}
+
+func encodeCSV(links []Link) ([]byte, error) {
+	// The time and tombstone columns are only written if some
+	// link needs them.
+	timed, deleted := false, false
+	for _, l := range links {
+		timed = timed || l.NotBefore != nil || l.ExpiresAt != nil
+		deleted = deleted || l.Deleted()
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := []string{"host", "path", "match", "url", "status", "query"}
+	if timed {
+		header = append(header, "not_before", "expires_at")
+	}
+	if deleted {
+		header = append(header, "deleted_at", "deleted_by", "delete_reason")
+	}
+	w.Write(header)
+	for _, l := range links {
+		status := ""
+		if l.Status != 0 {
+			status = strconv.Itoa(l.Status)
+		}
+		record := []string{l.Host, l.Path, l.Match, l.URL, status, string(l.Query)}
+		if timed {
+			record = append(record, formatCSVTime(l.NotBefore), formatCSVTime(l.ExpiresAt))
+		}
+		if deleted {
+			record = append(record, formatCSVTime(l.DeletedAt), l.DeletedBy, l.DeleteReason)
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func parseCSVTime(dst **time.Time, v string) error {
+	if v == "" {
+		return nil
+	}
+	t, err := ParseLinkTime(v)
+	if err != nil {
+		return err
+	}
+	*dst = &t
+	return nil
+}
+
+func formatCSVTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func parseCSV(data []byte) ([]Entry, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, col := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(col))
+		if _, ok := csvColumns[header[i]]; !ok {
+			return nil, fmt.Errorf("line 1: unknown column %q", col)
+		}
+	}
+	r.FieldsPerRecord = len(header)
+	var entries []Entry
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, column := r.FieldPos(0)
+		e := Entry{Pos: Pos{Line: line, Column: column}}
+		for i, v := range record {
+			if err := csvColumns[header[i]](&e.Link, v); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+}
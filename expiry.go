@@ -0,0 +1,65 @@
+package urlshort
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Links can be limited to a window of time, for campaigns and
+// other links that should only work for a while:
+//
+//     - path: /spring-sale
+//       url: https://www.example.com/sale
+//       not_before: 2025-03-01T00:00:00Z
+//       expires_at: 2025-04-01T00:00:00Z
+//
+// Before not_before the link doesn't exist as far as Handler
+// is concerned, so requests for it go to the fallback. From
+// expires_at on, Handler answers 410 Gone rather than falling
+// through to the fallback, or redirects to the URL set with
+// WithExpiryURL. Either bound may be left out. Times are
+// RFC 3339 timestamps; YAML and CSV files may also give just a
+// date, meaning midnight UTC, while TOML's local dates and
+// times are in the server's time zone.
+
+// ErrExpired is returned by Resolve for a link whose
+// expires_at has passed.
+var ErrExpired = errors.New("urlshort: link expired")
+
+// WithExpiryURL makes Handler redirect requests for expired
+// links to u with 302 Found, instead of answering 410 Gone.
+// WithExpiryURL panics if u is not an absolute http(s) URL.
+func WithExpiryURL(u string) Option {
+	if parsed, err := url.Parse(u); err != nil || !allowedSchemes[parsed.Scheme] || parsed.Host == "" {
+		panic(fmt.Sprintf("urlshort: invalid expiry URL %q", u))
+	}
+	return func(o *options) {
+		o.expiryURL = u
+	}
+}
+
+// Pending reports whether link isn't active yet at t.
+func (link Link) Pending(t time.Time) bool {
+	return link.NotBefore != nil && t.Before(*link.NotBefore)
+}
+
+// Expired reports whether link has expired at t.
+func (link Link) Expired(t time.Time) bool {
+	return link.ExpiresAt != nil && !t.Before(*link.ExpiresAt)
+}
+
+// ParseLinkTime parses a not_before or expires_at value given
+// as text, such as a CSV field or a command-line flag: an
+// RFC 3339 timestamp or a date, which means midnight UTC.
+func ParseLinkTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want an RFC 3339 timestamp or a date", s)
+	}
+	return t, nil
+}
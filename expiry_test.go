@@ -0,0 +1,144 @@
+package urlshort
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLinkWindow(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	yml := "- path: /expired\n  url: https://a.com\n  expires_at: " + past + "\n" +
+		"- path: /pending\n  url: https://b.com\n  not_before: " + future + "\n" +
+		"- path: /live\n  url: https://c.com\n  not_before: " + past + "\n  expires_at: " + future + "\n"
+
+	t.Run("it answers 410 for expired links", func(t *testing.T) {
+		handler, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := serve(handler, "/expired")
+		assertStatus(t, result, http.StatusGone)
+		assertStatus(t, serve(handler, "/live"), http.StatusFound)
+	})
+
+	t.Run("it falls back for links that aren't active yet", func(t *testing.T) {
+		handler, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := serve(handler, "/pending")
+		assertStatus(t, result, http.StatusOK)
+		assertBody(t, result, "fallback")
+	})
+
+	t.Run("it redirects expired links to the expiry URL", func(t *testing.T) {
+		handler, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback), WithExpiryURL("https://example.com/ended"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := serve(handler, "/expired")
+		assertStatus(t, result, http.StatusFound)
+		assertURL(t, result, "https://example.com/ended")
+	})
+
+	t.Run("it counts expired links and records expiry redirects", func(t *testing.T) {
+		m := NewMetrics(prometheus.NewRegistry())
+		var clicks []Click
+		sink := ClickSinkFunc(func(c Click) { clicks = append(clicks, c) })
+		gone, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback), WithMetrics(m), WithClickSink(sink))
+		if err != nil {
+			t.Fatal(err)
+		}
+		redirected, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback), WithMetrics(m), WithClickSink(sink), WithExpiryURL("https://example.com/ended"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		serve(gone, "/expired")
+		serve(redirected, "/expired")
+		if got := testutil.ToFloat64(m.redirects.WithLabelValues("410")); got != 1 {
+			t.Errorf("Expected 1 response with 410, got %v", got)
+		}
+		if got := testutil.ToFloat64(m.redirects.WithLabelValues("302")); got != 1 {
+			t.Errorf("Expected 1 redirect with 302, got %v", got)
+		}
+		if len(clicks) != 1 || clicks[0].URL != "https://example.com/ended" {
+			t.Errorf("Expected a click for the expiry redirect only, got %v", clicks)
+		}
+	})
+
+	t.Run("Resolve reports expired links", func(t *testing.T) {
+		store, err := NewYAMLStore([]byte(yml))
+		if err != nil {
+			t.Fatal(err)
+		}
+		link, _, status, err := Resolve(store, httptest.NewRequest("GET", "/expired", nil))
+		if !errors.Is(err, ErrExpired) || status != http.StatusGone || link.Path != "/expired" {
+			t.Errorf("Expected ErrExpired and 410 for /expired, got %v, %d, %v", link, status, err)
+		}
+		if _, _, _, err := Resolve(store, httptest.NewRequest("GET", "/pending", nil)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for /pending, got %v", err)
+		}
+	})
+
+	t.Run("it rejects empty windows", func(t *testing.T) {
+		bad := "- path: /a\n  url: https://a.com\n  not_before: " + future + "\n  expires_at: " + past + "\n"
+		_, err := NewYAMLStore([]byte(bad))
+		if err == nil || !strings.Contains(err.Error(), "is not before expires_at") {
+			t.Errorf("Expected an error for not_before after expires_at, got %v", err)
+		}
+	})
+}
+
+func TestLinkWindowFormats(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 4, 1, 12, 30, 0, 0, time.UTC)
+	want := []Link{{Path: "/sale", URL: "https://a.com", NotBefore: &start, ExpiresAt: &end}}
+	files := map[string]string{
+		"links.yaml": "- path: /sale\n  url: https://a.com\n  not_before: 2025-03-01\n  expires_at: 2025-04-01T12:30:00Z\n",
+		"links.json": `[{"path": "/sale", "url": "https://a.com", "not_before": "2025-03-01T00:00:00Z", "expires_at": "2025-04-01T12:30:00Z"}]`,
+		"links.toml": "[[links]]\npath = \"/sale\"\nurl = \"https://a.com\"\nnot_before = 2025-03-01T00:00:00Z\nexpires_at = 2025-04-01T12:30:00Z\n",
+		"links.csv":  "path,url,not_before,expires_at\n/sale,https://a.com,2025-03-01,2025-04-01T12:30:00Z\n",
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		links, err := LoadFile(path)
+		if err != nil {
+			t.Errorf("LoadFile(%s): %v", name, err)
+			continue
+		}
+		if len(links) != 1 || !links[0].NotBefore.Equal(start) || !links[0].ExpiresAt.Equal(end) {
+			t.Errorf("LoadFile(%s) = %v, want %v", name, links, want)
+			continue
+		}
+
+		// And the times survive a round trip.
+		out := filepath.Join(dir, "saved-"+name)
+		if err := SaveFile(out, links); err != nil {
+			t.Fatal(err)
+		}
+		saved, err := LoadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !saved[0].NotBefore.Equal(start) || !saved[0].ExpiresAt.Equal(end) {
+			t.Errorf("Expected the times to survive saving %s, got %v", name, saved)
+		}
+	}
+	if data, _ := EncodeLinks("csv", []Link{{Path: "/a", URL: "https://a.com"}}); strings.Contains(string(data), "expires_at") {
+		t.Errorf("Expected no time columns for links without times, got %q", data)
+	}
+}
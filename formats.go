@@ -0,0 +1,297 @@
+package urlshort
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Entry is a link decoded from a file along with where it was
+// found, so validation errors can point at it.
+type Entry struct {
+	Link
+	Pos Pos
+}
+
+// A Format decodes link definitions from one kind of file.
+// Formats add themselves to the registry with RegisterFormat,
+// usually from an init function; YAML, JSON, NDJSON, TOML and
+// CSV are built in.
+type Format struct {
+	// Name is a short, unique name such as "yaml".
+	Name string
+	// Extensions lists the file extensions, including the
+	// leading dot, that select this format.
+	Extensions []string
+	// Sniff reports whether data looks like this format. It
+	// is used for files whose extension isn't registered and
+	// may be nil for formats that can't be recognized by
+	// content.
+	Sniff func(data []byte) bool
+	// Decode parses data into entries. It should set the
+	// position of each entry where the format allows it.
+	Decode func(data []byte) ([]Entry, error)
+	// Encode writes links in this format. It may be nil for
+	// formats that can only be read.
+	Encode func(links []Link) ([]byte, error)
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[string]Format)
+)
+
+// RegisterFormat makes a link file format available to
+// LoadFile and FileStore. If RegisterFormat is called twice
+// with the same name, or with an extension already claimed by
+// another format, it panics.
+func RegisterFormat(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if f.Name == "" || f.Decode == nil {
+		panic("urlshort: RegisterFormat needs a name and a Decode function")
+	}
+	if _, dup := formats[f.Name]; dup {
+		panic("urlshort: RegisterFormat called twice for format " + f.Name)
+	}
+	for _, other := range formats {
+		for _, ext := range f.Extensions {
+			for _, taken := range other.Extensions {
+				if strings.EqualFold(ext, taken) {
+					panic(fmt.Sprintf("urlshort: extension %s of format %s is already used by %s", ext, f.Name, other.Name))
+				}
+			}
+		}
+	}
+	formats[f.Name] = f
+}
+
+// Formats returns the names of the registered formats, sorted.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FormatError is returned when a link file can't be decoded.
+// Format is the name of the format that was tried, or empty
+// if no format could be picked for the file.
+type FormatError struct {
+	Path   string
+	Format string
+	Err    error
+}
+
+func (e *FormatError) Error() string {
+	if e.Format == "" {
+		return fmt.Sprintf("urlshort: %s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("urlshort: %s: reading as %s: %v", e.Path, e.Format, e.Err)
+}
+
+func (e *FormatError) Unwrap() error { return e.Err }
+
+// LoadFile reads the link file at path, decodes it with the
+// format registered for its extension, or the first format
+// (by name) whose Sniff function recognizes its content, and
+// validates the result. Decoding errors are returned as a
+// *FormatError; validation errors are a LinkErrors.
+func LoadFile(path string, opts ...Option) ([]Link, error) {
+	o := newOptions(opts)
+	entries, err := readFile(path)
+	if err == nil {
+		foldEntries(entries, &o.aliases)
+		if err = validateEntries(entries, &o.aliases); err != nil {
+			err = fmt.Errorf("urlshort: %s: %w", path, err)
+		}
+	}
+	o.metrics.loaded(o.sourceName(path), liveEntries(entries), err)
+	if err != nil {
+		return nil, err
+	}
+	links := make([]Link, len(entries))
+	for i, e := range entries {
+		links[i] = e.Link
+	}
+	return links, nil
+}
+
+// SaveFile writes links to the file at path in the format
+// registered for its extension. The file is replaced
+// atomically, so readers such as a FileStore watching it never
+// see a partial write. Comments and formatting in an existing
+// file are not preserved.
+func SaveFile(path string, links []Link) error {
+	f, err := formatFor(path, nil)
+	if err != nil {
+		return &FormatError{Path: path, Err: err}
+	}
+	data, err := encodeLinks(f, links)
+	if err != nil {
+		return &FormatError{Path: path, Format: f.Name, Err: err}
+	}
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// EncodeLinks returns links encoded in the named format.
+func EncodeLinks(format string, links []Link) ([]byte, error) {
+	formatsMu.RLock()
+	f, ok := formats[format]
+	formatsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("urlshort: unknown format %q (known formats: %s)", format, strings.Join(Formats(), ", "))
+	}
+	data, err := encodeLinks(f, links)
+	if err != nil {
+		return nil, fmt.Errorf("urlshort: encoding %s: %w", format, err)
+	}
+	return data, nil
+}
+
+func encodeLinks(f Format, links []Link) ([]byte, error) {
+	if f.Encode == nil {
+		return nil, fmt.Errorf("format %s can't be written", f.Name)
+	}
+	if links == nil {
+		links = []Link{}
+	}
+	return f.Encode(links)
+}
+
+// readFile reads and decodes the link file at path.
+func readFile(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeFile(path, data)
+}
+
+// decodeFile decodes data read from path without validating
+// it.
+func decodeFile(path string, data []byte) ([]Entry, error) {
+	f, err := formatFor(path, data)
+	if err != nil {
+		return nil, &FormatError{Path: path, Err: err}
+	}
+	entries, err := f.Decode(data)
+	if err != nil {
+		return nil, &FormatError{Path: path, Format: f.Name, Err: err}
+	}
+	return entries, nil
+}
+
+// formatFor picks the format for a file by its extension, or
+// failing that by sniffing its content.
+func formatFor(path string, data []byte) (Format, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	ext := filepath.Ext(path)
+	if ext != "" {
+		for _, f := range formats {
+			for _, e := range f.Extensions {
+				if strings.EqualFold(e, ext) {
+					return f, nil
+				}
+			}
+		}
+	}
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if f := formats[name]; f.Sniff != nil && f.Sniff(data) {
+			return f, nil
+		}
+	}
+	return Format{}, fmt.Errorf("unrecognized format (tried extension %q and content; known formats: %s)",
+		ext, strings.Join(names, ", "))
+}
+
+// firstByte returns the first byte of data that isn't white
+// space, or 0 if there is none.
+func firstByte(data []byte) byte {
+	data = bytes.TrimLeft(data, " \t\r\n\ufeff")
+	if len(data) == 0 {
+		return 0
+	}
+	return data[0]
+}
+
+func init() {
+	RegisterFormat(Format{
+		Name:       "yaml",
+		Extensions: []string{".yaml", ".yml"},
+		Sniff: func(data []byte) bool {
+			for _, line := range bytes.Split(data, []byte("\n")) {
+				line = bytes.TrimSpace(line)
+				if len(line) == 0 || line[0] == '#' {
+					continue
+				}
+				return bytes.HasPrefix(line, []byte("- ")) || bytes.HasPrefix(line, []byte("---"))
+			}
+			return false
+		},
+		Decode: parseYAML,
+		Encode: func(links []Link) ([]byte, error) {
+			var buf bytes.Buffer
+			enc := yaml.NewEncoder(&buf)
+			enc.SetIndent(2)
+			if err := enc.Encode(links); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), enc.Close()
+		},
+	})
+	RegisterFormat(Format{
+		Name:       "json",
+		Extensions: []string{".json"},
+		Sniff: func(data []byte) bool {
+			if firstByte(data) != '[' {
+				return false
+			}
+			rest := bytes.TrimLeft(data, " \t\r\n\ufeff")[1:]
+			c := firstByte(rest)
+			return c == '{' || c == ']'
+		},
+		Decode: parseJSON,
+		Encode: func(links []Link) ([]byte, error) {
+			data, err := json.MarshalIndent(links, "", "  ")
+			return append(data, '\n'), err
+		},
+	})
+}
@@ -0,0 +1,98 @@
+package urlshort
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadFile(t *testing.T) {
+	want := []Link{
+		{Path: "/a", URL: "https://a.com"},
+		{Path: "/b", URL: "https://b.com", Status: 301},
+	}
+	files := map[string]string{
+		"links.yaml": "- path: /a\n  url: https://a.com\n- path: /b\n  url: https://b.com\n  status: 301\n",
+		"links.json": `[{"path": "/a", "url": "https://a.com"}, {"path": "/b", "url": "https://b.com", "status": 301}]`,
+		"links.ndjson": "{\"path\": \"/a\", \"url\": \"https://a.com\"}\n\n" +
+			"{\"path\": \"/b\", \"url\": \"https://b.com\", \"status\": 301}\n",
+		"links.toml": "[[links]]\npath = \"/a\"\nurl = \"https://a.com\"\n\n" +
+			"[[links]]\npath = \"/b\"\nurl = \"https://b.com\"\nstatus = 301\n",
+		"links.csv": "path,url,status\n/a,https://a.com,\n/b,https://b.com,301\n",
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		for _, path := range []string{name, strings.Replace(name, "links.", "sniffed-", 1) + ".txt"} {
+			path = filepath.Join(dir, path)
+			if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			links, err := LoadFile(path)
+			if err != nil {
+				t.Errorf("LoadFile(%s): %v", filepath.Base(path), err)
+				continue
+			}
+			if !reflect.DeepEqual(links, want) {
+				t.Errorf("LoadFile(%s) = %v, want %v", filepath.Base(path), links, want)
+			}
+		}
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	_, err := LoadFile(write("bad.toml", "[[links]]\npath = /a\n"))
+	var ferr *FormatError
+	if !errors.As(err, &ferr) || ferr.Format != "toml" {
+		t.Errorf("Expected a toml FormatError, got %v", err)
+	}
+
+	_, err = LoadFile(write("mystery.dat", "hello"))
+	if !errors.As(err, &ferr) || ferr.Format != "" || !strings.Contains(err.Error(), "unrecognized format") {
+		t.Errorf("Expected an unrecognized format error, got %v", err)
+	}
+
+	_, err = LoadFile(write("dup.csv", "path,url\n/a,https://a.com\n/a,https://b.com\n"))
+	var lerrs LinkErrors
+	if !errors.As(err, &lerrs) || lerrs[0].Pos.Line != 3 {
+		t.Errorf("Expected a LinkErrors pointing at line 3, got %v", err)
+	}
+}
+
+func TestSaveFile(t *testing.T) {
+	want := []Link{
+		{Host: "go.corp", Path: "/a", URL: "https://a.com", Query: QueryAppend},
+		{Path: "/b", URL: "https://b.com", Status: 301},
+		{Match: `^/c/(\d+)$`, URL: "https://c.com/$1"},
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"links.yaml", "links.json", "links.ndjson", "links.toml", "links.csv"} {
+		path := filepath.Join(dir, name)
+		if err := SaveFile(path, want); err != nil {
+			t.Errorf("SaveFile(%s): %v", name, err)
+			continue
+		}
+		links, err := LoadFile(path)
+		if err != nil {
+			t.Errorf("LoadFile(%s): %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(links, want) {
+			t.Errorf("LoadFile(%s) = %v, want %v", name, links, want)
+		}
+	}
+	if _, err := EncodeLinks("xml", want); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -1,18 +1,91 @@
 package urlshort
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Handler will return an http.HandlerFunc (which also
+// implements http.Handler) that looks up the request host and
+// path in store and redirects to the URL it maps to (see
+// host.go for how host-scoped links are matched). If the store
+// returns ErrNotFound, then the fallback http.Handler will be
+// called instead. Any other store error results in a 500.
+//
+// The redirect uses the link's own Status if it has one, and
+// otherwise 302 Found or the code set with WithStatus. The
+// query string of the request is handled by the link's Query
+// policy, or the one set with WithQueryPolicy. Links outside
+// their not_before and expires_at window and soft deleted
+// links are handled as described in expiry.go and
+// tombstone.go. Redirects are reported to the ClickSink set
+// with WithClickSink, if any, and redirects, fallbacks and
+// errors are counted in the Metrics set with WithMetrics.
+// Wrapped in an AccessLog, it reports the link it matched or
+// that it used the fallback.
+//
+// MapHandler and YAMLHandler are thin wrappers around Handler
+// for the common in-memory cases.
+func Handler(store Store, fallback http.Handler, opts ...Option) http.HandlerFunc {
+	o := newOptions(opts)
+	return func(w http.ResponseWriter, r *http.Request) {
+		link, dest, status, err := o.resolve(store, r)
+		switch {
+		case err == nil:
+			if o.clicks != nil {
+				o.clicks.Record(newClick(r, link, dest))
+			}
+			o.metrics.redirected(status)
+			recordMatch(r, link, dest)
+			http.Redirect(w, r, dest, status)
+		case errors.Is(err, ErrExpired), errors.Is(err, ErrDeleted):
+			o.metrics.redirected(status)
+			recordMatch(r, link, dest)
+			if dest != "" {
+				if o.clicks != nil {
+					o.clicks.Record(newClick(r, link, dest))
+				}
+				http.Redirect(w, r, dest, status)
+				return
+			}
+			serveGone(w, r, link)
+		case errors.Is(err, ErrNotFound):
+			o.metrics.fellBack()
+			recordFallback(r)
+			fallback.ServeHTTP(w, r)
+		default:
+			o.metrics.failed()
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}
+}
+
+// Resolve looks up the link for r in store the way Handler
+// does, and returns it along with the URL and status code
+// Handler would redirect to. It returns ErrNotFound where
+// Handler would call the fallback, including for links that
+// aren't active yet. For an expired link it returns
+// ErrExpired along with the link and status 410, or the
+// expiry URL and 302 if WithExpiryURL is set, and for a
+// tombstone ErrDeleted, the link and 410.
+func Resolve(store Store, r *http.Request, opts ...Option) (link Link, dest string, status int, err error) {
+	return newOptions(opts).resolve(store, r)
+}
+
 // MapHandler will return an http.HandlerFunc (which also
 // implements http.Handler) that will attempt to map any
 // paths (keys in the map) to their corresponding URL (values
 // that each key in the map points to, in string format).
 // If the path is not provided in the map, then the fallback
 // http.Handler will be called instead.
-func MapHandler(pathsToUrls map[string]string, fallback http.Handler) http.HandlerFunc {
-	//	TODO: Implement this...
-	return nil
+func MapHandler(pathsToUrls map[string]string, fallback http.Handler, opts ...Option) http.HandlerFunc {
+	return Handler(NewMapStore(pathsToUrls), fallback, opts...)
 }
 
 // YAMLHandler will parse the provided YAML and then return
@@ -26,12 +99,184 @@ func MapHandler(pathsToUrls map[string]string, fallback http.Handler) http.Handl
 //     - path: /some-path
 //       url: https://www.some-url.com/demo
 //
+// A path ending in "/*" matches everything under it, with the
+// rest of the request path carried over to the URL:
+//
+//     - path: /gh/*
+//       url: https://github.com/*
+//
+// and placeholders in the path are substituted into the URL:
+//
+//     - path: /jira/{id}
+//       url: https://jira.example.com/browse/{id}
+//
+// Entries can also be regular expressions, tried in order
+// after exact paths miss:
+//
+//     - match: ^/docs/v(\d+)/(.*)$
+//       url: https://docs.example.com/$2?version=$1
+//
+// Any entry may set its own redirect status (301, 302, 303,
+// 307 or 308) to override the handler's default:
+//
+//     - path: /home
+//       url: https://www.example.com
+//       status: 301
+//
+// and its own query policy (drop, append, merge-incoming or
+// merge-destination; see QueryPolicy):
+//
+//     - path: /promo
+//       url: https://www.example.com/sale?utm_source=short
+//       query: merge-incoming
+//
+// Entries with a host only match requests for that host, and
+// take priority over entries without one:
+//
+//     - host: go.corp
+//       path: /help
+//       url: https://wiki.corp/help
+//
 // The only errors that can be returned all related to having
-// invalid YAML data.
+// invalid YAML data. Links that don't make sense, such as
+// duplicate paths, URLs that aren't absolute http(s) URLs or
+// paths not allowed by the AliasPolicy (see WithAliasPolicy),
+// are reported together as a LinkErrors with the line and
+// column of each offending entry.
 //
 // See MapHandler to create a similar http.HandlerFunc via
 // a mapping of paths to urls.
-func YAMLHandler(yml []byte, fallback http.Handler) (http.HandlerFunc, error) {
-	// TODO: Implement this...
-	return nil, nil
+func YAMLHandler(yml []byte, fallback http.Handler, opts ...Option) (http.HandlerFunc, error) {
+	store, err := NewYAMLStore(yml, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return Handler(store, fallback, opts...), nil
+}
+
+// NewYAMLStore parses and validates YAML in the format
+// described for YAMLHandler and returns a MapStore holding the
+// links, for use as a source of a LayeredStore or any other
+// Store consumer. With WithMetrics, the load is counted, and
+// the links gauge set, under the name set with WithSource or
+// else "yaml".
+func NewYAMLStore(yml []byte, opts ...Option) (*MapStore, error) {
+	o := newOptions(opts)
+	entries, err := parseYAML(yml)
+	if err != nil {
+		o.metrics.loaded(o.sourceName("yaml"), 0, err)
+		return nil, err
+	}
+	store, err := buildStore(entries, o)
+	o.metrics.loaded(o.sourceName("yaml"), liveEntries(entries), err)
+	if err != nil {
+		return nil, fmt.Errorf("urlshort: %w", err)
+	}
+	return store, nil
+}
+
+// JSONHandler is the JSON equivalent of YAMLHandler. The JSON
+// is expected to be an array of objects in the format:
+//
+//	[{"path": "/some-path", "url": "https://www.some-url.com/demo"}]
+func JSONHandler(data []byte, fallback http.Handler, opts ...Option) (http.HandlerFunc, error) {
+	store, err := NewJSONStore(data, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return Handler(store, fallback, opts...), nil
+}
+
+// NewJSONStore is the JSON equivalent of NewYAMLStore.
+func NewJSONStore(data []byte, opts ...Option) (*MapStore, error) {
+	o := newOptions(opts)
+	entries, err := parseJSON(data)
+	if err != nil {
+		o.metrics.loaded(o.sourceName("json"), 0, err)
+		return nil, err
+	}
+	store, err := buildStore(entries, o)
+	o.metrics.loaded(o.sourceName("json"), liveEntries(entries), err)
+	if err != nil {
+		return nil, fmt.Errorf("urlshort: %w", err)
+	}
+	return store, nil
+}
+
+// parseYAML decodes a YAML list of links, recording the line
+// and column of each one.
+func parseYAML(yml []byte) ([]Entry, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(yml, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	list := doc.Content[0]
+	if list.Kind == yaml.ScalarNode && list.Tag == "!!null" {
+		return nil, nil
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("yaml: line %d: expected a list of links", list.Line)
+	}
+	entries := make([]Entry, len(list.Content))
+	for i, item := range list.Content {
+		if err := item.Decode(&entries[i].Link); err != nil {
+			return nil, err
+		}
+		entries[i].Pos = Pos{Line: item.Line, Column: item.Column}
+	}
+	return entries, nil
+}
+
+// parseJSON decodes a JSON array of links, recording the byte
+// offset (and line and column) of each one.
+func parseJSON(data []byte) ([]Entry, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('[') {
+		return nil, fmt.Errorf("json: expected an array of links")
+	}
+	var entries []Entry
+	for dec.More() {
+		offset := dec.InputOffset()
+		for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,", data[offset]) >= 0 {
+			offset++
+		}
+		var e Entry
+		if err := dec.Decode(&e.Link); err != nil {
+			return nil, fmt.Errorf("json: offset %d: %w", offset, err)
+		}
+		line := bytes.Count(data[:offset], []byte("\n")) + 1
+		column := int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+		e.Pos = Pos{Line: line, Column: column, Offset: offset}
+		entries = append(entries, e)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// buildStore validates the parsed entries and puts them in a
+// MapStore. The error, if any, is a LinkErrors listing every
+// invalid entry.
+func buildStore(entries []Entry, o *options) (*MapStore, error) {
+	foldEntries(entries, &o.aliases)
+	if err := validateEntries(entries, &o.aliases); err != nil {
+		return nil, err
+	}
+	store := NewMapStore(nil)
+	for _, e := range entries {
+		if e.Match != "" {
+			r, _ := compileRule(e.Link)
+			store.rules = append(store.rules, r)
+			continue
+		}
+		store.links[e.Key()] = e.Link
+	}
+	store.reindex()
+	return store, nil
 }
@@ -0,0 +1,303 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const fallbackResponse = "fallback"
+
+func fallback(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, fallbackResponse)
+}
+
+func TestMapHandler(t *testing.T) {
+	handler := MapHandler(map[string]string{"/test": "https://test.com"}, http.HandlerFunc(fallback))
+
+	t.Run("it uses the fallback for unknown routes", func(t *testing.T) {
+		result := serve(handler, "/unknown")
+
+		assertBody(t, result, fallbackResponse)
+	})
+
+	t.Run("it redirects for found url", func(t *testing.T) {
+		result := serve(handler, "/test")
+
+		assertStatus(t, result, http.StatusFound)
+		assertURL(t, result, "https://test.com")
+	})
+}
+
+func TestYAMLHandler(t *testing.T) {
+	yml := `
+- path: /test
+  url: https://test.com
+`
+	handler, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertBody(t, serve(handler, "/unknown"), fallbackResponse)
+	assertURL(t, serve(handler, "/test"), "https://test.com")
+
+	if _, err := YAMLHandler([]byte("- path: [oops"), http.HandlerFunc(fallback)); err == nil {
+		t.Error("Expected an error for invalid YAML")
+	}
+}
+
+func TestHandlerStoreError(t *testing.T) {
+	store := StoreFunc(func(ctx context.Context, path string) (Link, error) {
+		return Link{}, errors.New("backend down")
+	})
+
+	result := serve(Handler(store, http.HandlerFunc(fallback)), "/test")
+
+	assertStatus(t, result, http.StatusInternalServerError)
+}
+
+func serve(handler http.Handler, target string) *http.Response {
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response.Result()
+}
+
+func assertStatus(t *testing.T, resp *http.Response, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		t.Errorf("Expected status to be %d, got %d", want, resp.StatusCode)
+	}
+}
+
+func assertBody(t *testing.T, resp *http.Response, want string) {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("Could not read response body", err)
+	}
+	if got := string(body); got != want {
+		t.Errorf("Expected response body to be %q, got %q", want, got)
+	}
+}
+
+func assertURL(t *testing.T, resp *http.Response, want string) {
+	t.Helper()
+	url, err := resp.Location()
+	if err != nil {
+		t.Fatal("Could not read location", err)
+	}
+	if url.String() != want {
+		t.Errorf("Expected url to be %s, got %s", want, url)
+	}
+}
+
+func TestWildcardRoutes(t *testing.T) {
+	yml := `
+- path: /gh/*
+  url: https://github.com/*
+- path: /gh/go/*
+  url: https://github.com/golang
+- path: /gh/gophercises
+  url: https://gophercises.com
+`
+	handler, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/gh/gophercises/urlshort", "https://github.com/gophercises/urlshort"},
+		{"/gh/gophercises", "https://gophercises.com"},
+		{"/gh/go/go/issues", "https://github.com/golang/go/issues"},
+		{"/gh", "https://github.com/"},
+		{"/gh/a%20b", "https://github.com/a%20b"},
+	}
+	for _, tt := range tests {
+		assertURL(t, serve(handler, tt.path), tt.want)
+	}
+	assertBody(t, serve(handler, "/ghost"), fallbackResponse)
+}
+
+func TestTemplateRoutes(t *testing.T) {
+	yml := `
+- path: /jira/{id}
+  url: https://jira.example.com/browse/{id}
+- path: /pr/{num:int}
+  url: https://github.com/org/repo/pull/{num}
+- path: /search/{q:slug}
+  url: https://example.com/search?q={q}
+- path: /src/{file:path}
+  url: https://example.com/blob/main/{file}
+- path: /jira/new
+  url: https://jira.example.com/create
+`
+	handler, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/jira/ABC-123", "https://jira.example.com/browse/ABC-123"},
+		{"/jira/new", "https://jira.example.com/create"},
+		{"/jira/a%3Fb", "https://jira.example.com/browse/a%3Fb"},
+		{"/pr/4521", "https://github.com/org/repo/pull/4521"},
+		{"/search/go-lang", "https://example.com/search?q=go-lang"},
+		{"/src/cmd/go/main.go", "https://example.com/blob/main/cmd/go/main.go"},
+	}
+	for _, tt := range tests {
+		assertURL(t, serve(handler, tt.path), tt.want)
+	}
+	for _, path := range []string{"/pr/abc", "/jira/a/b", "/jira/", "/src/"} {
+		assertBody(t, serve(handler, path), fallbackResponse)
+	}
+
+	invalid := []string{
+		"- {path: /a/{x}, url: https://a.com/{x}}\n- {path: /a/{y:int}, url: https://b.com/{y}}",
+		"- {path: /a/{x}/b, url: https://a.com}\n- {path: /a/c/{y}, url: https://b.com}",
+		"- {path: /a/{x:path}/b, url: https://a.com}",
+		"- {path: /a/{x}, url: https://a.com/{y}}",
+		"- {path: /a/{x:uuid}, url: https://a.com}",
+		"- {path: /a/ABC-{x}, url: https://a.com}",
+	}
+	for _, yml := range invalid {
+		if _, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback)); err == nil {
+			t.Errorf("Expected an error for %s", yml)
+		}
+	}
+}
+
+func TestRegexRules(t *testing.T) {
+	yml := `
+- path: /docs/v1/intro
+  url: https://legacy.example.com/intro
+- match: ^/docs/v(\d+)/(.*)$
+  url: https://docs.example.com/$2?version=$1
+- match: ^/docs/(?P<page>.*)$
+  url: https://docs.example.com/latest/${page}
+`
+	handler, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertURL(t, serve(handler, "/docs/v1/intro"), "https://legacy.example.com/intro")
+	assertURL(t, serve(handler, "/docs/v2/api/types"), "https://docs.example.com/api/types?version=2")
+	assertURL(t, serve(handler, "/docs/faq"), "https://docs.example.com/latest/faq")
+	assertBody(t, serve(handler, "/doc"), fallbackResponse)
+
+	_, err = YAMLHandler([]byte("- {path: /a, url: https://a.com}\n- {match: '^/(b', url: https://b.com}"), http.HandlerFunc(fallback))
+	if err == nil || !strings.Contains(err.Error(), "entry 1") {
+		t.Errorf("Expected an error naming entry 1, got %v", err)
+	}
+	_, err = YAMLHandler([]byte("- {match: '^/(b)', url: https://b.com/$2}"), http.HandlerFunc(fallback))
+	if err == nil {
+		t.Error("Expected an error for a reference to a missing group")
+	}
+}
+
+func TestRedirectStatus(t *testing.T) {
+	yml := `
+- path: /permanent
+  url: https://a.com
+  status: 301
+- path: /default
+  url: https://b.com
+`
+	handler, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback), WithStatus(http.StatusTemporaryRedirect))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertStatus(t, serve(handler, "/permanent"), http.StatusMovedPermanently)
+	assertStatus(t, serve(handler, "/default"), http.StatusTemporaryRedirect)
+
+	if _, err := YAMLHandler([]byte("- {path: /a, url: https://a.com, status: 200}"), http.HandlerFunc(fallback)); err == nil {
+		t.Error("Expected an error for a non-redirect status")
+	}
+}
+
+func TestQueryPolicy(t *testing.T) {
+	yml := `
+- path: /drop
+  url: https://a.com/x?a=1
+  query: drop
+- path: /append
+  url: https://a.com/x?a=1#top
+  query: append
+- path: /incoming
+  url: https://a.com/x?a=1&b=2
+  query: merge-incoming
+- path: /destination
+  url: https://a.com/x?a=1&b=2
+  query: merge-destination
+- path: /default
+  url: https://a.com/x
+`
+	handler, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback), WithQueryPolicy(QueryAppend))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/drop?ref=news", "https://a.com/x?a=1"},
+		{"/append?a=2", "https://a.com/x?a=1&a=2#top"},
+		{"/incoming?a=9&c=3", "https://a.com/x?a=9&b=2&c=3"},
+		{"/destination?a=9&c=3", "https://a.com/x?a=1&b=2&c=3"},
+		{"/default?ref=news", "https://a.com/x?ref=news"},
+		{"/incoming", "https://a.com/x?a=1&b=2"},
+	}
+	for _, tt := range tests {
+		assertURL(t, serve(handler, tt.path), tt.want)
+	}
+
+	if _, err := YAMLHandler([]byte("- {path: /a, url: https://a.com, query: keep}"), http.HandlerFunc(fallback)); err == nil {
+		t.Error("Expected an error for an unknown query policy")
+	}
+}
+
+func TestHostRouting(t *testing.T) {
+	yml := `
+- host: go.corp
+  path: /help
+  url: https://wiki.corp/help
+- host: l.example.com
+  path: /gh/*
+  url: https://github.com/example/*
+- path: /help
+  url: https://www.example.com/support
+`
+	handler, err := YAMLHandler([]byte(yml), http.HandlerFunc(fallback))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host, path, want string
+	}{
+		{"go.corp", "/help", "https://wiki.corp/help"},
+		{"GO.Corp:8080", "/help", "https://wiki.corp/help"},
+		{"l.example.com", "/help", "https://www.example.com/support"},
+		{"l.example.com", "/gh/urlshort", "https://github.com/example/urlshort"},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		request.Host = tt.host
+		response := httptest.NewRecorder()
+		handler(response, request)
+		assertURL(t, response.Result(), tt.want)
+	}
+	assertBody(t, serve(handler, "/gh/urlshort"), fallbackResponse)
+}
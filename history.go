@@ -0,0 +1,486 @@
+package urlshort
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// A RecordingStore given a History keeps every version of its
+// links. Each Put or Delete that changes a link is recorded in
+// the History as a Revision holding the link before and after
+// the change, who made it and when, numbered from 1 for each
+// key:
+//
+//     {"key": "/a", "version": 2, "op": "update", "old": {...}, "new": {...}, "author": "alice", "time": "..."}
+//
+// The author is the actor of the context passed to Put or
+// Delete (see ContextWithActor); Admin sets it for every
+// request. Rollback puts a link back the way it was after an
+// earlier revision, which is recorded as a revision of its
+// own, so rollbacks can be rolled back too.
+//
+// Changes made to the wrapped store directly, or to a link
+// file by hand, are not recorded.
+
+// A RevisionOp says what kind of change a Revision records.
+type RevisionOp string
+
+const (
+	OpCreate   RevisionOp = "create"   // a new link
+	OpUpdate   RevisionOp = "update"   // a changed link
+	OpDelete   RevisionOp = "delete"   // a link turned into a tombstone
+	OpRestore  RevisionOp = "restore"  // a tombstone turned back into a link
+	OpPurge    RevisionOp = "purge"    // a link or tombstone removed for good
+	OpRollback RevisionOp = "rollback" // a link put back to an earlier version
+)
+
+// A Revision is one recorded change to the link stored under
+// Key. Old is nil for a created link and New for a purged one.
+type Revision struct {
+	Key     string     `json:"key"`
+	Version int        `json:"version"`
+	Op      RevisionOp `json:"op"`
+	Old     *Link      `json:"old,omitempty"`
+	New     *Link      `json:"new,omitempty"`
+	Author  string     `json:"author,omitempty"`
+	Time    time.Time  `json:"time"`
+	// RolledBackTo is the version an OpRollback revision
+	// restored.
+	RolledBackTo int `json:"rolled_back_to,omitempty"`
+}
+
+// OldURL returns the URL of the link before the change, or ""
+// if there was none.
+func (rev Revision) OldURL() string {
+	if rev.Old == nil {
+		return ""
+	}
+	return rev.Old.URL
+}
+
+// NewURL returns the URL of the link after the change, or ""
+// if it was purged.
+func (rev Revision) NewURL() string {
+	if rev.New == nil {
+		return ""
+	}
+	return rev.New.URL
+}
+
+// A History stores the revisions of links.
+type History interface {
+	// Record stores rev as the next version of rev.Key and
+	// returns it with its Version set.
+	Record(ctx context.Context, rev Revision) (Revision, error)
+	// Revisions returns the revisions of the link stored
+	// under key, oldest first.
+	Revisions(ctx context.Context, key string) ([]Revision, error)
+}
+
+type actorKey struct{}
+
+// ContextWithActor returns a copy of ctx carrying actor, the
+// user on whose behalf changes are made with it.
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set with
+// ContextWithActor, or "".
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+type rollbackKey struct{}
+
+// RecordingStore is a MutableStore recording the changes made
+// through it, as revisions in a History and as events in an
+// AuditLog.
+type RecordingStore struct {
+	store   MutableStore
+	history History
+	audit   *AuditLog
+	mu      sync.Mutex // makes reading the old link, writing and recording one step
+}
+
+// NewRecordingStore returns a RecordingStore that keeps the
+// links in store and records changes to them in history and
+// audit, either of which may be nil.
+func NewRecordingStore(store MutableStore, history History, audit *AuditLog) *RecordingStore {
+	return &RecordingStore{store: store, history: history, audit: audit}
+}
+
+func (s *RecordingStore) Lookup(ctx context.Context, key string) (Link, error) {
+	return s.store.Lookup(ctx, key)
+}
+
+// List lists the links of the wrapped store, which must be a
+// Lister.
+func (s *RecordingStore) List(ctx context.Context) ([]Link, error) {
+	lister, ok := s.store.(Lister)
+	if !ok {
+		return nil, errors.New("urlshort: store can't list links")
+	}
+	return lister.List(ctx)
+}
+
+// Put stores link and records the change. It does nothing if
+// link is exactly the same as the one it replaces.
+func (s *RecordingStore) Put(ctx context.Context, link Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := currentLink(ctx, s.store, link.Key())
+	if err != nil {
+		return err
+	}
+	if old != nil && reflect.DeepEqual(*old, link) {
+		return nil
+	}
+	if err := s.store.Put(ctx, link); err != nil {
+		return err
+	}
+	return s.record(ctx, link.Key(), old, &link)
+}
+
+// PutAll stores links with a single PutAll to the wrapped
+// store (see BulkStore) and records the changes, leaving out
+// the links that are exactly the same as the ones they
+// replace.
+func (s *RecordingStore) PutAll(ctx context.Context, links []Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Compare each link with the one before it in links, if
+	// any, rather than the one in the store.
+	olds := make(map[string]*Link)
+	var changed []Link
+	var before []*Link
+	for _, link := range links {
+		old, ok := olds[link.Key()]
+		if !ok {
+			var err error
+			if old, err = currentLink(ctx, s.store, link.Key()); err != nil {
+				return err
+			}
+		}
+		if old != nil && reflect.DeepEqual(*old, link) {
+			continue
+		}
+		link := link
+		olds[link.Key()] = &link
+		changed = append(changed, link)
+		before = append(before, old)
+	}
+	if err := PutAll(ctx, s.store, changed); err != nil {
+		return err
+	}
+	for i := range changed {
+		if err := s.record(ctx, changed[i].Key(), before[i], &changed[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the link stored under key and records the
+// change.
+func (s *RecordingStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := currentLink(ctx, s.store, key)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Delete(ctx, key); err != nil {
+		return err
+	}
+	return s.record(ctx, key, old, nil)
+}
+
+// DeleteRule removes a match rule from the wrapped store, which
+// must be a RuleDeleter, and records the change under the
+// rule's key.
+func (s *RecordingStore) DeleteRule(ctx context.Context, host, match string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	links, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	var old *Link
+	for _, link := range links {
+		if link.Match == match && canonicalHost(link.Host) == canonicalHost(host) {
+			old = &link
+			break
+		}
+	}
+	if err := DeleteRule(ctx, s.store, host, match); err != nil {
+		return err
+	}
+	return s.record(ctx, canonicalHost(host), old, nil)
+}
+
+// record records the change to key in the history and the
+// audit log. The change has been made already, so a failure to
+// record it in one doesn't stop it being recorded in the other.
+func (s *RecordingStore) record(ctx context.Context, key string, old, new *Link) error {
+	op, version := changeOp(ctx, old, new)
+	var errs []error
+	if s.history != nil {
+		rev := Revision{Key: key, Op: op, Old: old, New: new, Author: ActorFromContext(ctx), Time: time.Now().UTC(), RolledBackTo: version}
+		if _, err := s.history.Record(ctx, rev); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	ev := AuditEvent{Action: string(op), Key: key, Old: old, New: new}
+	if op == OpRollback {
+		ev.Detail = fmt.Sprintf("rolled back to version %d", version)
+	}
+	if err := s.audit.Record(ctx, ev); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("urlshort: recording the change to %s: %w", key, err)
+	}
+	return nil
+}
+
+// currentLink returns the link stored under key, or nil if
+// there is none.
+func currentLink(ctx context.Context, store Store, key string) (*Link, error) {
+	link, err := lookupKey(ctx, store, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// changeOp says what kind of change replacing old with new is,
+// either of which may be nil. For a change made by Rollback it
+// also returns the version rolled back to.
+func changeOp(ctx context.Context, old, new *Link) (RevisionOp, int) {
+	if version, ok := ctx.Value(rollbackKey{}).(int); ok {
+		return OpRollback, version
+	}
+	switch {
+	case new == nil:
+		return OpPurge, 0
+	case old == nil:
+		return OpCreate, 0
+	case !old.Deleted() && new.Deleted():
+		return OpDelete, 0
+	case old.Deleted() && !new.Deleted():
+		return OpRestore, 0
+	}
+	return OpUpdate, 0
+}
+
+// Rollback puts the link stored under key back the way it was
+// after the given version, taken from history, and returns
+// it. The whole link is restored with a single Put, or Delete
+// if that version purged it, in which case the zero Link is
+// returned. Through a RecordingStore using history, possibly
+// wrapped in other stores, the rollback is recorded as a new
+// revision. Rollback fails with ErrNotFound if key has no
+// such version.
+func Rollback(ctx context.Context, store MutableStore, history History, key string, version int) (Link, error) {
+	rev, err := findRevision(ctx, history, key, version)
+	if err != nil {
+		return Link{}, err
+	}
+	ctx = context.WithValue(ctx, rollbackKey{}, version)
+	if rev.New == nil {
+		if _, err := lookupKey(ctx, store, key); errors.Is(err, ErrNotFound) {
+			return Link{}, nil
+		}
+		return Link{}, store.Delete(ctx, key)
+	}
+	if err := store.Put(ctx, *rev.New); err != nil {
+		return Link{}, err
+	}
+	return *rev.New, nil
+}
+
+// findRevision returns the given version of key from history.
+func findRevision(ctx context.Context, history History, key string, version int) (Revision, error) {
+	revs, err := history.Revisions(ctx, key)
+	if err != nil {
+		return Revision{}, err
+	}
+	for _, rev := range revs {
+		if rev.Version == version {
+			return rev, nil
+		}
+	}
+	return Revision{}, fmt.Errorf("%w: %s has no version %d", ErrNotFound, key, version)
+}
+
+// MemoryHistory is a History kept in memory.
+type MemoryHistory struct {
+	mu        sync.Mutex
+	revisions map[string][]Revision
+}
+
+// NewMemoryHistory returns an empty MemoryHistory.
+func NewMemoryHistory() *MemoryHistory {
+	return &MemoryHistory{revisions: make(map[string][]Revision)}
+}
+
+func (m *MemoryHistory) Record(ctx context.Context, rev Revision) (Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rev.Version = len(m.revisions[rev.Key]) + 1
+	m.revisions[rev.Key] = append(m.revisions[rev.Key], rev)
+	return rev, nil
+}
+
+func (m *MemoryHistory) Revisions(ctx context.Context, key string) ([]Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Revision(nil), m.revisions[key]...), nil
+}
+
+// FileHistory is a History kept in an NDJSON file, one
+// revision per line, which is only ever appended to. The file
+// is created by the first Record. Lines appended by other
+// processes are picked up before every Record and Revisions,
+// so commands can record changes to a store that a server is
+// also changing. Versions are numbered by the order of the
+// lines in the file, not the version written in them, which
+// two writers may have picked at once.
+type FileHistory struct {
+	path string
+
+	mu        sync.Mutex
+	file      *os.File // for appending, opened by the first Record
+	offset    int64    // how much of the file has been read
+	line      int
+	revisions map[string][]Revision
+}
+
+// OpenFileHistory returns a FileHistory kept in the file at
+// path, reading the revisions already in it.
+func OpenFileHistory(path string) (*FileHistory, error) {
+	h := &FileHistory{path: path, revisions: make(map[string][]Revision)}
+	if _, err := h.catchUp(0); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *FileHistory) Record(ctx context.Context, rev Revision) (Revision, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := h.catchUp(0); err != nil {
+		return Revision{}, err
+	}
+	rev.Version = len(h.revisions[rev.Key]) + 1 // a guess, see below
+	data, err := json.Marshal(rev)
+	if err != nil {
+		return Revision{}, err
+	}
+	if h.file == nil {
+		f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return Revision{}, err
+		}
+		h.file = f
+	}
+	if _, err := h.file.Write(append(data, '\n')); err != nil {
+		return Revision{}, err
+	}
+	// Appending leaves the file offset at the end of our line.
+	// Read it back rather than adding rev directly, in case
+	// another process appended to the file meanwhile and took
+	// the version we guessed.
+	end, err := h.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Revision{}, err
+	}
+	version, err := h.catchUp(end)
+	if err != nil {
+		return Revision{}, err
+	}
+	if version == 0 {
+		return Revision{}, fmt.Errorf("urlshort: %s: can't find the revision just written", h.path)
+	}
+	rev.Version = version
+	return rev, nil
+}
+
+func (h *FileHistory) Revisions(ctx context.Context, key string) ([]Revision, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := h.catchUp(0); err != nil {
+		return nil, err
+	}
+	return append([]Revision(nil), h.revisions[key]...), nil
+}
+
+// Close closes the file.
+func (h *FileHistory) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
+
+// catchUp reads the complete lines added to the file since it
+// was last read, and returns the version of the revision whose
+// line ends at offset mark, or 0 if there is none.
+func (h *FileHistory) catchUp(mark int64) (int, error) {
+	f, err := os.Open(h.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(h.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(f)
+	var marked int
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial line is still being written.
+			return marked, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		h.offset += int64(len(line))
+		h.line++
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var rev Revision
+		if err := json.Unmarshal(line, &rev); err != nil {
+			return 0, fmt.Errorf("urlshort: %s:%d: %w", h.path, h.line, err)
+		}
+		rev.Version = len(h.revisions[rev.Key]) + 1
+		h.revisions[rev.Key] = append(h.revisions[rev.Key], rev)
+		if h.offset == mark {
+			marked = rev.Version
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package urlshort
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRecordingStore(t *testing.T) {
+	ctx := ContextWithActor(context.Background(), "alice")
+	history := NewMemoryHistory()
+	store := NewRecordingStore(NewMapStore(nil), history, nil)
+	handler := Handler(store, http.HandlerFunc(fallback))
+
+	store.Put(ctx, Link{Path: "/a", URL: "https://one.com"})
+	store.Put(ctx, Link{Path: "/a", URL: "https://one.com"}) // no change
+	store.Put(ContextWithActor(ctx, "bob"), Link{Path: "/a", URL: "https://two.com"})
+	if _, err := SoftDelete(context.Background(), store, "/a", "carol", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(ctx, store, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	store.Delete(ctx, "/a")
+
+	revs, err := history.Revisions(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		op      RevisionOp
+		author  string
+		oldURL  string
+		newURL  string
+		version int
+	}{
+		{OpCreate, "alice", "", "https://one.com", 1},
+		{OpUpdate, "bob", "https://one.com", "https://two.com", 2},
+		{OpDelete, "carol", "https://two.com", "https://two.com", 3},
+		{OpRestore, "alice", "https://two.com", "https://two.com", 4},
+		{OpPurge, "alice", "https://two.com", "", 5},
+	}
+	if len(revs) != len(want) {
+		t.Fatalf("Expected %d revisions, got %v", len(want), revs)
+	}
+	for i, w := range want {
+		rev := revs[i]
+		if rev.Op != w.op || rev.Author != w.author || rev.OldURL() != w.oldURL || rev.NewURL() != w.newURL || rev.Version != w.version {
+			t.Errorf("Revision %d: expected %v, got %+v", i+1, w, rev)
+		}
+	}
+
+	t.Run("it rolls back to an earlier version", func(t *testing.T) {
+		link, err := Rollback(ctx, store, history, "/a", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if link.URL != "https://one.com" {
+			t.Errorf("Expected the first URL back, got %v", link)
+		}
+		result := serve(handler, "/a")
+		assertStatus(t, result, http.StatusFound)
+		assertURL(t, result, "https://one.com")
+		revs, _ := history.Revisions(ctx, "/a")
+		if last := revs[len(revs)-1]; last.Op != OpRollback || last.RolledBackTo != 1 || last.Version != 6 {
+			t.Errorf("Expected the rollback to be recorded, got %+v", last)
+		}
+	})
+
+	t.Run("it rolls back deletions", func(t *testing.T) {
+		if _, err := Rollback(ctx, store, history, "/a", 3); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, serve(handler, "/a"), http.StatusGone)
+		if _, err := Rollback(ctx, store, history, "/a", 5); err != nil {
+			t.Fatal(err)
+		}
+		result := serve(handler, "/a")
+		assertStatus(t, result, http.StatusOK)
+		assertBody(t, result, "fallback")
+	})
+
+	t.Run("it fails for unknown versions", func(t *testing.T) {
+		if _, err := Rollback(ctx, store, history, "/a", 99); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestRecordingStoreAudit(t *testing.T) {
+	log, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.ndjson"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	ctx := ContextWithActor(context.Background(), "alice")
+	history := NewMemoryHistory()
+	store := NewRecordingStore(NewMapStore(nil), history, log)
+
+	store.Put(ctx, Link{Path: "/a", URL: "https://one.com"})
+	store.Put(ctx, Link{Path: "/a", URL: "https://one.com"}) // no change
+	store.Put(ctx, Link{Path: "/a", URL: "https://two.com"})
+	if _, err := Rollback(ctx, store, history, "/a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	revs, _ := history.Revisions(ctx, "/a")
+	events, err := log.Query(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 3 || len(events) != 3 {
+		t.Fatalf("Expected 3 revisions and 3 events, got %v and %v", revs, events)
+	}
+	for i, ev := range events {
+		if ev.Action != string(revs[i].Op) || ev.Actor != revs[i].Author || ev.New.URL != revs[i].NewURL() {
+			t.Errorf("Expected event %d to match %+v, got %+v", i, revs[i], ev)
+		}
+	}
+	if events[2].Detail != "rolled back to version 1" {
+		t.Errorf("Expected the rollback's version in the event, got %q", events[2].Detail)
+	}
+}
+
+func TestFileHistory(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.history")
+	first, err := OpenFileHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := OpenFileHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	// Two writers, as with a server and a command sharing a
+	// store, number the versions of a key between them.
+	links := NewMapStore(nil)
+	if err := NewRecordingStore(links, first, nil).Put(ctx, Link{Path: "/a", URL: "https://one.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewRecordingStore(links, second, nil).Put(ctx, Link{Path: "/a", URL: "https://two.com"}); err != nil {
+		t.Fatal(err)
+	}
+	revs, err := first.Revisions(ctx, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) < 2 || revs[1].Version != 2 || revs[1].NewURL() != "https://two.com" {
+		t.Errorf("Expected the second writer's revision as version 2, got %v", revs)
+	}
+
+	reopened, err := OpenFileHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revs, _ := reopened.Revisions(ctx, "/a"); len(revs) == 0 || revs[0].OldURL() != "" || revs[0].Op != OpCreate {
+		t.Errorf("Expected the revisions to be read back, got %v", revs)
+	}
+
+	t.Run("it numbers revisions from concurrent writers once each", func(t *testing.T) {
+		const writers, n = 8, 200
+		recorded := make(chan Revision, writers*n)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			h, err := OpenFileHistory(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer h.Close()
+			wg.Add(1)
+			go func(h *FileHistory) {
+				defer wg.Done()
+				for i := 0; i < n; i++ {
+					rev, err := h.Record(ctx, Revision{Key: "/b", Op: OpUpdate, New: &Link{Path: "/b", URL: fmt.Sprintf("https://b.com/%p/%d", h, i)}})
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					recorded <- rev
+				}
+			}(h)
+		}
+		wg.Wait()
+		close(recorded)
+
+		revs, err := reopened.Revisions(ctx, "/b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revs) != writers*n {
+			t.Fatalf("Expected %d revisions, got %d", writers*n, len(revs))
+		}
+		for i, rev := range revs {
+			if rev.Version != i+1 {
+				t.Fatalf("Expected revision %d to be version %d, got %d", i, i+1, rev.Version)
+			}
+		}
+		for rev := range recorded {
+			if got := revs[rev.Version-1]; got.NewURL() != rev.NewURL() {
+				t.Errorf("Expected version %d to be %s, got %s", rev.Version, rev.NewURL(), got.NewURL())
+			}
+		}
+	})
+}
+
+func TestAdminHistory(t *testing.T) {
+	history := NewMemoryHistory()
+	admin := NewAdmin(NewRecordingStore(NewMapStore(nil), history, nil))
+	admin.TrustProxyUser = true
+	do := func(method, target, body string) *http.Response {
+		t.Helper()
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		request.Header.Set("X-Forwarded-User", "erin")
+		response := httptest.NewRecorder()
+		admin.ServeHTTP(response, request)
+		return response.Result()
+	}
+
+	assertStatus(t, do("GET", "/api/history/a", ""), http.StatusNotImplemented)
+	admin.History = history
+	assertStatus(t, do("POST", "/api/links", `{"path": "/a", "url": "https://one.com"}`), http.StatusCreated)
+	assertStatus(t, do("PUT", "/api/links/a", `{"url": "https://two.com"}`), http.StatusOK)
+
+	var revs []Revision
+	json.NewDecoder(do("GET", "/api/history/a", "").Body).Decode(&revs)
+	if len(revs) != 2 || revs[1].Author != "erin" || revs[1].OldURL() != "https://one.com" {
+		t.Errorf("Unexpected history %v", revs)
+	}
+	assertStatus(t, do("GET", "/api/history/b", ""), http.StatusNotFound)
+
+	assertStatus(t, do("POST", "/api/rollback/a", ""), http.StatusBadRequest)
+	assertStatus(t, do("POST", "/api/rollback/a?version=9", ""), http.StatusNotFound)
+	var link Link
+	json.NewDecoder(do("POST", "/api/rollback/a?version=1", "").Body).Decode(&link)
+	if link.URL != "https://one.com" {
+		t.Errorf("Expected the rollback to return the first version, got %v", link)
+	}
+}
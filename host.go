@@ -0,0 +1,76 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Links can be scoped to a host so that several short domains
+// can be served from one handler without their paths
+// colliding:
+//
+//     - host: go.corp
+//       path: /help
+//       url: https://wiki.corp/help
+//     - path: /help
+//       url: https://www.example.com/support
+//
+// A host-scoped link is stored under its Key, which is the
+// host followed by the path ("go.corp/help"). Handler first
+// looks up the request's host and path, and only if that
+// misses looks up the path on its own, so host-less links act
+// as a default for every host.
+
+// Key returns the key link is stored under: its path, or for
+// host-scoped links the canonical host followed by the path.
+func (link Link) Key() string {
+	return canonicalHost(link.Host) + link.Path
+}
+
+// canonicalHost lowercases host and strips any port and
+// trailing dot, so that "Go.Corp:8080" and "go.corp." both
+// become "go.corp".
+func canonicalHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimPrefix(strings.TrimSuffix(host, "]"), "[")
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// splitKey splits a store key into its host, which is empty
+// for host-less links, and path.
+func splitKey(key string) (host, path string) {
+	i := strings.IndexByte(key, '/')
+	if i < 0 {
+		return "", key
+	}
+	return key[:i], key[i:]
+}
+
+// lookupRequest looks up the link for r in store, trying the
+// request's host-scoped key before its bare path. If fold is
+// set and neither matches, it tries again with the path
+// lowercased.
+func lookupRequest(ctx context.Context, store Store, r *http.Request, fold bool) (Link, error) {
+	link, err := lookupPath(ctx, store, r.Host, r.URL.Path)
+	if fold && errors.Is(err, ErrNotFound) {
+		if lower := strings.ToLower(r.URL.Path); lower != r.URL.Path {
+			return lookupPath(ctx, store, r.Host, lower)
+		}
+	}
+	return link, err
+}
+
+func lookupPath(ctx context.Context, store Store, host, path string) (Link, error) {
+	if host := canonicalHost(host); host != "" {
+		link, err := store.Lookup(ctx, host+path)
+		if !errors.Is(err, ErrNotFound) {
+			return link, err
+		}
+	}
+	return store.Lookup(ctx, path)
+}
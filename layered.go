@@ -0,0 +1,200 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Source is a named Store that is one layer of a LayeredStore.
+// The name is only used to report where a link came from.
+type Source struct {
+	Name  string
+	Store Store
+}
+
+// LayeredStore looks up keys in an ordered list of sources and
+// returns the link from the first one that has it, so that
+// precedence between, say, links created at runtime, a link
+// file and built-in defaults is a list rather than a chain of
+// nested fallback handlers:
+//
+//     store := urlshort.NewLayeredStore(
+//         urlshort.Source{Name: "admin", Store: runtime},
+//         urlshort.Source{Name: "links.yaml", Store: file},
+//         urlshort.Source{Name: "defaults", Store: defaults},
+//     )
+//
+// A source that fails with anything other than ErrNotFound
+// stops the lookup with that error rather than falling
+// through to a lower source, which could serve a stale link.
+type LayeredStore struct {
+	sources []Source
+}
+
+// NewLayeredStore returns a LayeredStore trying sources in the
+// given order, highest priority first.
+func NewLayeredStore(sources ...Source) *LayeredStore {
+	return &LayeredStore{sources: sources}
+}
+
+// Lookup returns the link for key from the first source that
+// has one.
+func (s *LayeredStore) Lookup(ctx context.Context, key string) (Link, error) {
+	link, _, err := s.LookupSource(ctx, key)
+	return link, err
+}
+
+// LookupSource is like Lookup, but also returns the name of
+// the source that supplied the link.
+func (s *LayeredStore) LookupSource(ctx context.Context, key string) (Link, string, error) {
+	for _, src := range s.sources {
+		link, err := src.Store.Lookup(ctx, key)
+		switch {
+		case err == nil:
+			return link, src.Name, nil
+		case !errors.Is(err, ErrNotFound):
+			return Link{}, src.Name, fmt.Errorf("urlshort: source %s: %w", src.Name, err)
+		}
+	}
+	return Link{}, "", ErrNotFound
+}
+
+// List returns the links that lookups can reach, sorted by
+// key and followed by the regex rules of each source in
+// order. Sources that aren't a Lister are skipped, and a link
+// is left out if a higher source has one with the same key.
+func (s *LayeredStore) List(ctx context.Context) ([]Link, error) {
+	var links, rules []Link
+	seen := make(map[string]bool)
+	for _, src := range s.sources {
+		lister, ok := src.Store.(Lister)
+		if !ok {
+			continue
+		}
+		l, err := lister.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("urlshort: source %s: %w", src.Name, err)
+		}
+		for _, link := range l {
+			if link.Match != "" {
+				rules = append(rules, link)
+				continue
+			}
+			if !seen[link.Key()] {
+				seen[link.Key()] = true
+				links = append(links, link)
+			}
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Key() < links[j].Key() })
+	return append(links, rules...), nil
+}
+
+// Writer returns a MutableStore for changing the links of the
+// source with the given name, which must be a MutableStore. A
+// link written through it may not take a key that another
+// source already answers for, with a link of its own or a
+// wildcard, template or rule matching it; Put fails with
+// ErrConflict instead, so that runtime changes neither shadow
+// nor get shadowed by the other sources. Lookups and listing
+// only see the source itself.
+func (s *LayeredStore) Writer(name string) (MutableStore, error) {
+	for i, src := range s.sources {
+		if src.Name != name {
+			continue
+		}
+		store, ok := src.Store.(MutableStore)
+		if !ok {
+			return nil, fmt.Errorf("urlshort: source %s can't be changed", name)
+		}
+		return &layerWriter{MutableStore: store, layered: s, index: i}, nil
+	}
+	return nil, fmt.Errorf("urlshort: no source named %s", name)
+}
+
+type layerWriter struct {
+	MutableStore
+	layered *LayeredStore
+	index   int
+}
+
+func (w *layerWriter) Put(ctx context.Context, link Link) error {
+	for i, src := range w.layered.sources {
+		if i == w.index {
+			continue
+		}
+		_, err := src.Store.Lookup(ctx, link.Key())
+		if err == nil {
+			return fmt.Errorf("%w: %s is already served by source %s", ErrConflict, link.Key(), src.Name)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("urlshort: source %s: %w", src.Name, err)
+		}
+	}
+	return w.MutableStore.Put(ctx, link)
+}
+
+// List lists the links of the source, which must be a Lister.
+func (w *layerWriter) List(ctx context.Context) ([]Link, error) {
+	lister, ok := w.MutableStore.(Lister)
+	if !ok {
+		return nil, errors.New("urlshort: store can't list links")
+	}
+	return lister.List(ctx)
+}
+
+// Shadow is a link that can never be served because a higher
+// priority source answers for its key first.
+type Shadow struct {
+	Link   Link
+	Source string // where Link is defined
+	By     string // the source that wins
+	ByLink Link   // the link served instead
+}
+
+func (s Shadow) String() string {
+	return fmt.Sprintf("%s from %s is shadowed by %s -> %s from %s",
+		s.Link.Key(), s.Source, s.ByLink.Key(), s.ByLink.URL, s.By)
+}
+
+// Shadowed returns the links of every source that is a Lister
+// whose key a higher source resolves first, either with a link
+// of its own or with a wildcard, template or rule matching it.
+// Servers can log the result at startup to catch links that
+// were overridden by accident. Wildcards and templates are
+// only reported as shadowed by a link with the same key.
+func (s *LayeredStore) Shadowed(ctx context.Context) ([]Shadow, error) {
+	var shadows []Shadow
+	for i, src := range s.sources {
+		lister, ok := src.Store.(Lister)
+		if !ok || i == 0 {
+			continue
+		}
+		links, err := lister.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("urlshort: source %s: %w", src.Name, err)
+		}
+		for _, link := range links {
+			if link.Match != "" {
+				continue
+			}
+			for _, higher := range s.sources[:i] {
+				by, err := higher.Store.Lookup(ctx, link.Key())
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				if err != nil {
+					return nil, fmt.Errorf("urlshort: source %s: %w", higher.Name, err)
+				}
+				if isPattern(link) && by.Key() != link.Key() {
+					continue
+				}
+				shadows = append(shadows, Shadow{Link: link, Source: src.Name, By: higher.Name, ByLink: by})
+				break
+			}
+		}
+	}
+	return shadows, nil
+}
@@ -0,0 +1,97 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLayeredStore(t *testing.T) {
+	ctx := context.Background()
+	admin := NewMapStore(map[string]string{"/a": "https://admin.example.com/a"})
+	file, err := NewYAMLStore([]byte(`
+- path: /gh/*
+  url: https://github.com/*
+- path: /b
+  url: https://file.example.com/b
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := NewMapStore(map[string]string{
+		"/a":              "https://defaults.example.com/a",
+		"/gh/gophercises": "https://gophercises.com",
+		"/c":              "https://defaults.example.com/c",
+	})
+	store := NewLayeredStore(
+		Source{Name: "admin", Store: admin},
+		Source{Name: "file", Store: file},
+		Source{Name: "defaults", Store: defaults},
+	)
+
+	for _, tc := range []struct{ key, url, source string }{
+		{"/a", "https://admin.example.com/a", "admin"},
+		{"/b", "https://file.example.com/b", "file"},
+		{"/c", "https://defaults.example.com/c", "defaults"},
+		{"/gh/gophercises", "https://github.com/gophercises", "file"},
+	} {
+		link, source, err := store.LookupSource(ctx, tc.key)
+		if err != nil {
+			t.Fatalf("LookupSource(%s): %v", tc.key, err)
+		}
+		if link.URL != tc.url || source != tc.source {
+			t.Errorf("Expected %s to map to %s from %s, got %s from %s", tc.key, tc.url, tc.source, link.URL, source)
+		}
+	}
+	if _, _, err := store.LookupSource(ctx, "/d"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for /d, got %v", err)
+	}
+
+	shadows, err := store.Shadowed(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range shadows {
+		got = append(got, s.String())
+	}
+	want := []string{
+		"/a from defaults is shadowed by /a -> https://admin.example.com/a from admin",
+		"/gh/gophercises from defaults is shadowed by /gh/* -> https://github.com/gophercises from file",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Shadowed() = %q, want %q", got, want)
+	}
+
+	t.Run("it only writes keys no other source serves", func(t *testing.T) {
+		writer, err := store.Writer("admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"/b", "/gh/x", "/c"} {
+			if err := writer.Put(ctx, Link{Path: key, URL: "https://admin.example.com"}); !errors.Is(err, ErrConflict) {
+				t.Errorf("Expected ErrConflict putting %s, got %v", key, err)
+			}
+		}
+		if err := writer.Put(ctx, Link{Path: "/d", URL: "https://admin.example.com/d"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, source, _ := store.LookupSource(ctx, "/d"); source != "admin" {
+			t.Errorf("Expected /d to be served by admin, got %q", source)
+		}
+		if _, err := store.Writer("nope"); err == nil {
+			t.Error("Expected an error for an unknown source")
+		}
+	})
+
+	broken := NewLayeredStore(
+		Source{Name: "broken", Store: StoreFunc(func(ctx context.Context, key string) (Link, error) {
+			return Link{}, errors.New("connection refused")
+		})},
+		Source{Name: "defaults", Store: defaults},
+	)
+	if _, err := broken.Lookup(ctx, "/c"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a source error not to fall through, got %v", err)
+	}
+}
@@ -1,36 +1,149 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gophercises/urlshort"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	linksFile := flag.String("links", "", "link file to serve, in any supported format (reloaded when it changes)")
+	reload := flag.Duration("reload", 2*time.Second, "how often to check the link file for changes")
+	boltFile := flag.String("bolt", "", "Bolt database of links to serve")
+	admin := flag.Bool("admin", false, "serve the admin API at /api/links for managing links at runtime")
+	metricsOn := flag.Bool("metrics", false, "serve Prometheus metrics at /metrics")
+	accessLogFormat := flag.String("access-log", "", "log every request to stderr as text or json")
+	trustProxyUser := flag.Bool("trust-proxy-user", false, "take the admin API user from the X-Forwarded-User header of an authenticating proxy")
+	codeSpec := flag.String("codes", "random:7", "how /api/shorten generates codes: random, hash or sequential, optionally with :length or :start")
+	flag.Parse()
+	codes, err := urlshort.ParseCodeStrategy(*codeSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mux := defaultMux()
 
-	// Build the MapHandler using the mux as the fallback
-	pathsToUrls := map[string]string{
-		"/urlshort-godoc": "https://godoc.org/github.com/gophercises/urlshort",
-		"/yaml-godoc":     "https://godoc.org/gopkg.in/yaml.v2",
+	// Metrics stay nil, and record nothing, unless asked for.
+	reg := prometheus.NewRegistry()
+	var metrics *urlshort.Metrics
+	if *metricsOn {
+		reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		metrics = urlshort.NewMetrics(reg)
+	}
+
+	// Sources are listed highest priority first; a path is
+	// served by the first source that has it.
+	var sources []urlshort.Source
+	// Closed, in order, when the server stops.
+	var closers []func() error
+
+	// Links created through the admin API are kept in memory
+	// and take priority over everything else.
+	var runtime *urlshort.MapStore
+	if *admin {
+		runtime = urlshort.NewMapStore(nil)
+		sources = append(sources, urlshort.Source{Name: "admin", Store: metrics.InstrumentStore("admin", runtime)})
+	}
+
+	// Optionally serve links from a file, picking up edits
+	// without a restart.
+	if *linksFile != "" {
+		store, err := urlshort.NewFileStore(*linksFile, urlshort.WithMetrics(metrics))
+		if err != nil {
+			panic(err)
+		}
+		go store.Watch(context.Background(), *reload)
+		sources = append(sources, urlshort.Source{Name: *linksFile, Store: metrics.InstrumentStore(*linksFile, store)})
+	}
+
+	if *boltFile != "" {
+		store, err := urlshort.OpenBoltStore(*boltFile, urlshort.WithMetrics(metrics))
+		if err != nil {
+			panic(err)
+		}
+		closers = append(closers, store.Close)
+		sources = append(sources, urlshort.Source{Name: *boltFile, Store: metrics.InstrumentStore(*boltFile, store)})
 	}
-	mapHandler := urlshort.MapHandler(pathsToUrls, mux)
 
-	// Build the YAMLHandler using the mapHandler as the
-	// fallback
 	yaml := `
 - path: /urlshort
   url: https://github.com/gophercises/urlshort
 - path: /urlshort-final
   url: https://github.com/gophercises/urlshort/tree/solution
 `
-	yamlHandler, err := urlshort.YAMLHandler([]byte(yaml), mapHandler)
+	yamlStore, err := urlshort.NewYAMLStore([]byte(yaml), urlshort.WithMetrics(metrics), urlshort.WithSource("built-in YAML"))
 	if err != nil {
 		panic(err)
 	}
+	sources = append(sources, urlshort.Source{Name: "built-in YAML", Store: metrics.InstrumentStore("built-in YAML", yamlStore)})
+
+	pathsToUrls := map[string]string{
+		"/urlshort-godoc": "https://godoc.org/github.com/gophercises/urlshort",
+		"/yaml-godoc":     "https://godoc.org/gopkg.in/yaml.v2",
+	}
+	sources = append(sources, urlshort.Source{Name: "built-in map", Store: metrics.InstrumentStore("built-in map", urlshort.NewMapStore(pathsToUrls))})
+
+	store := urlshort.NewLayeredStore(sources...)
+	shadows, err := store.Shadowed(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	for _, s := range shadows {
+		log.Printf("warning: %v", s)
+	}
+
+	// Anything no source has goes to the mux.
+	root := http.NewServeMux()
+	opts := []urlshort.Option{urlshort.WithMetrics(metrics)}
+	if *admin {
+		// Count clicks for the admin API to report.
+		clicks := urlshort.NewClickCounter()
+		sink := urlshort.NewAsyncSink(clicks, 1024)
+		closers = append([]func() error{sink.Close}, closers...)
+		// Links created at runtime may not clash with the
+		// ones from the other sources.
+		writer, err := store.Writer("admin")
+		if err != nil {
+			log.Fatal(err)
+		}
+		api := urlshort.NewAdmin(writer, urlshort.WithMetrics(metrics), urlshort.WithSource("admin"))
+		api.Clicks = clicks
+		api.Shortener = &urlshort.Shortener{Codes: codes}
+		api.TrustProxyUser = *trustProxyUser
+		root.Handle("/api/", api)
+		opts = append(opts, urlshort.WithClickSink(sink))
+	}
+	if *metricsOn {
+		root.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	}
+	var redirects http.Handler = urlshort.Handler(store, mux, opts...)
+	// Optionally log every request, noting which link served it.
+	switch *accessLogFormat {
+	case "text":
+		accessLog := &urlshort.AccessLog{Logger: slog.New(slog.NewTextHandler(os.Stderr, nil))}
+		redirects = accessLog.Wrap(redirects)
+	case "json":
+		accessLog := &urlshort.AccessLog{Logger: slog.New(slog.NewJSONHandler(os.Stderr, nil))}
+		redirects = accessLog.Wrap(redirects)
+	}
+	root.Handle("/", redirects)
+
 	fmt.Println("Starting the server on :8080")
-	http.ListenAndServe(":8080", yamlHandler)
+	err = http.ListenAndServe(":8080", root)
+	for _, c := range closers {
+		c()
+	}
+	log.Fatal(err)
 }
 
 func defaultMux() *http.ServeMux {
@@ -0,0 +1,230 @@
+package urlshort
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the Prometheus collectors for a urlshort server.
+// Pass them to Handler with WithMetrics to count redirects and
+// fallbacks, to the loaders (YAMLHandler, NewYAMLStore,
+// NewFileStore and friends) to count loads and the links they
+// hold, and wrap stores with InstrumentStore to time their
+// lookups. Serve them from a registry the usual way:
+//
+//     reg := prometheus.NewRegistry()
+//     m := urlshort.NewMetrics(reg)
+//     mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+//
+// A nil *Metrics records nothing.
+type Metrics struct {
+	redirects    *prometheus.CounterVec
+	fallbacks    prometheus.Counter
+	lookupErrors prometheus.Counter
+	lookups      *prometheus.HistogramVec
+	loads        *prometheus.CounterVec
+	links        *prometheus.GaugeVec
+}
+
+// lookupBuckets suit in-memory lookups, which take
+// microseconds, as well as database ones.
+var lookupBuckets = []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// NewMetrics creates the urlshort collectors and registers
+// them with reg. It panics if any of them is already
+// registered.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "urlshort_redirects_total",
+			Help: "Redirects served, by status code, with 410 for expired and deleted links.",
+		}, []string{"status"}),
+		fallbacks: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "urlshort_fallbacks_total",
+			Help: "Requests passed to the fallback handler because no link matched.",
+		}),
+		lookupErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "urlshort_lookup_errors_total",
+			Help: "Requests answered with a 500 because the store failed.",
+		}),
+		lookups: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "urlshort_lookup_duration_seconds",
+			Help:    "Time taken by store lookups, by store.",
+			Buckets: lookupBuckets,
+		}, []string{"store"}),
+		loads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "urlshort_loads_total",
+			Help: "Loads and reloads of link sources, by source and result (success or failure).",
+		}, []string{"source", "result"}),
+		links: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "urlshort_links_loaded",
+			Help: "Links held by each loaded source.",
+		}, []string{"source"}),
+	}
+	reg.MustRegister(m.redirects, m.fallbacks, m.lookupErrors, m.lookups, m.loads, m.links)
+	return m
+}
+
+// WithMetrics makes Handler count redirects and fallbacks in
+// m, and loaders count loads and links in m.
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithSource names the source that loaders report to the
+// metrics set with WithMetrics. NewFileStore and LoadFile
+// default to the file path, and the other loaders to their
+// format.
+func WithSource(name string) Option {
+	return func(o *options) {
+		o.source = name
+	}
+}
+
+func (m *Metrics) redirected(status int) {
+	if m != nil {
+		m.redirects.WithLabelValues(strconv.Itoa(status)).Inc()
+	}
+}
+
+func (m *Metrics) fellBack() {
+	if m != nil {
+		m.fallbacks.Inc()
+	}
+}
+
+func (m *Metrics) failed() {
+	if m != nil {
+		m.lookupErrors.Inc()
+	}
+}
+
+// loaded records a load of source that ended with err or, if
+// err is nil, left it holding n links.
+func (m *Metrics) loaded(source string, n int, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.loads.WithLabelValues(source, "failure").Inc()
+		return
+	}
+	m.loads.WithLabelValues(source, "success").Inc()
+	m.setLinks(source, n)
+}
+
+// setLinks records that source now holds n links.
+func (m *Metrics) setLinks(source string, n int) {
+	if m != nil {
+		m.links.WithLabelValues(source).Set(float64(n))
+	}
+}
+
+// loadedStore records a load of source, which left it holding
+// the links listed by store.
+func (m *Metrics) loadedStore(ctx context.Context, source string, store Lister) {
+	if m == nil {
+		return
+	}
+	links, err := store.List(ctx)
+	m.loaded(source, liveLinks(links), err)
+}
+
+// recount sets the links gauge of source to the number of
+// links listed by store, after a change to them.
+func (m *Metrics) recount(ctx context.Context, source string, store Lister) {
+	if m == nil {
+		return
+	}
+	if links, err := store.List(ctx); err == nil {
+		m.setLinks(source, liveLinks(links))
+	}
+}
+
+// liveLinks returns how many of links aren't tombstones, which
+// is what the links gauge counts.
+func liveLinks(links []Link) int {
+	n := 0
+	for _, link := range links {
+		if !link.Deleted() {
+			n++
+		}
+	}
+	return n
+}
+
+// liveEntries is liveLinks for entries.
+func liveEntries(entries []Entry) int {
+	n := 0
+	for _, e := range entries {
+		if !e.Link.Deleted() {
+			n++
+		}
+	}
+	return n
+}
+
+// InstrumentStore returns a Store that times the lookups of
+// store in the lookup histogram under the given name. The
+// result is also a MutableStore or Lister when store is, so
+// it can stand in for store anywhere, such as in a Source of
+// a LayeredStore. Every lookup is timed, whether it finds a
+// link, misses or fails.
+func (m *Metrics) InstrumentStore(name string, store Store) Store {
+	if m == nil {
+		return store
+	}
+	t := &timedStore{store: store, observer: m.lookups.WithLabelValues(name)}
+	ms, mutable := store.(MutableStore)
+	l, lister := store.(Lister)
+	switch {
+	case mutable && lister:
+		return &timedMutableLister{timedStore: t, storeWriter: ms, Lister: l}
+	case mutable:
+		return &timedMutable{timedStore: t, storeWriter: ms}
+	case lister:
+		return &timedLister{timedStore: t, Lister: l}
+	}
+	return t
+}
+
+type timedStore struct {
+	store    Store
+	observer prometheus.Observer
+}
+
+func (t *timedStore) Lookup(ctx context.Context, key string) (Link, error) {
+	start := time.Now()
+	link, err := t.store.Lookup(ctx, key)
+	t.observer.Observe(time.Since(start).Seconds())
+	return link, err
+}
+
+// storeWriter is the part of MutableStore beyond Lookup, so
+// that it can be embedded next to a timedStore without the
+// Lookup methods colliding.
+type storeWriter interface {
+	Put(ctx context.Context, link Link) error
+	Delete(ctx context.Context, key string) error
+}
+
+type timedMutable struct {
+	*timedStore
+	storeWriter
+}
+
+type timedLister struct {
+	*timedStore
+	Lister
+}
+
+type timedMutableLister struct {
+	*timedStore
+	storeWriter
+	Lister
+}
@@ -0,0 +1,187 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(reg)
+
+	yml := "- path: /a\n  url: https://a.com\n- path: /b\n  url: https://b.com\n  status: 301\n"
+	yamlStore, err := NewYAMLStore([]byte(yml), WithMetrics(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := StoreFunc(func(ctx context.Context, key string) (Link, error) {
+		if key == "/boom" {
+			return Link{}, errors.New("backend down")
+		}
+		return Link{}, ErrNotFound
+	})
+	store := NewLayeredStore(
+		Source{Name: "broken", Store: m.InstrumentStore("broken", broken)},
+		Source{Name: "yaml", Store: m.InstrumentStore("yaml", yamlStore)},
+	)
+	handler := Handler(store, http.HandlerFunc(fallback), WithMetrics(m))
+
+	for _, target := range []string{"/a", "/a", "/b", "/nope", "/boom"} {
+		serve(handler, target)
+	}
+
+	t.Run("it counts redirects by status", func(t *testing.T) {
+		if got := testutil.ToFloat64(m.redirects.WithLabelValues("302")); got != 2 {
+			t.Errorf("Expected 2 redirects with 302, got %v", got)
+		}
+		if got := testutil.ToFloat64(m.redirects.WithLabelValues("301")); got != 1 {
+			t.Errorf("Expected 1 redirect with 301, got %v", got)
+		}
+	})
+
+	t.Run("it counts fallbacks and errors", func(t *testing.T) {
+		if got := testutil.ToFloat64(m.fallbacks); got != 1 {
+			t.Errorf("Expected 1 fallback, got %v", got)
+		}
+		if got := testutil.ToFloat64(m.lookupErrors); got != 1 {
+			t.Errorf("Expected 1 lookup error, got %v", got)
+		}
+	})
+
+	t.Run("it times lookups per store", func(t *testing.T) {
+		// /boom stops at the broken store, everything else
+		// falls through to the YAML one.
+		if got := testutil.CollectAndCount(m.lookups); got != 2 {
+			t.Errorf("Expected histograms for 2 stores, got %d", got)
+		}
+		want := `
+# HELP urlshort_links_loaded Links held by each loaded source.
+# TYPE urlshort_links_loaded gauge
+urlshort_links_loaded{source="yaml"} 2
+`
+		if err := testutil.CollectAndCompare(m.links, strings.NewReader(want)); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it keeps the capabilities of instrumented stores", func(t *testing.T) {
+		wrapped := m.InstrumentStore("map", NewMapStore(nil))
+		if _, ok := wrapped.(MutableStore); !ok {
+			t.Error("Expected an instrumented MapStore to be a MutableStore")
+		}
+		if _, ok := wrapped.(Lister); !ok {
+			t.Error("Expected an instrumented MapStore to be a Lister")
+		}
+		if _, ok := m.InstrumentStore("func", broken).(Lister); ok {
+			t.Error("Expected an instrumented StoreFunc not to be a Lister")
+		}
+	})
+
+	t.Run("it is served by promhttp", func(t *testing.T) {
+		resp := serve(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}), "/metrics")
+		assertStatus(t, resp, http.StatusOK)
+		body, _ := io.ReadAll(resp.Body)
+		for _, name := range []string{
+			`urlshort_redirects_total{status="302"} 2`,
+			`urlshort_fallbacks_total 1`,
+			`urlshort_loads_total{result="success",source="yaml"} 1`,
+			`urlshort_lookup_duration_seconds_count{store="yaml"}`,
+		} {
+			if !strings.Contains(string(body), name) {
+				t.Errorf("Expected the metrics to contain %s", name)
+			}
+		}
+	})
+}
+
+func TestFileStoreMetrics(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry())
+	path := filepath.Join(t.TempDir(), "links.yaml")
+	writeFile(t, path, "- path: /a\n  url: https://a.com\n")
+	store, err := NewFileStore(path, WithMetrics(m), WithSource("links"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, path, "- path: a\n  url: https://a.com\n")
+	if err := store.Reload(); err == nil {
+		t.Fatal("Expected an error for a path without a leading slash")
+	}
+	if err := store.Put(context.Background(), Link{Path: "/b", URL: "https://b.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(m.loads.WithLabelValues("links", "success")); got != 1 {
+		t.Errorf("Expected 1 successful load, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.loads.WithLabelValues("links", "failure")); got != 1 {
+		t.Errorf("Expected 1 failed load, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.links.WithLabelValues("links")); got != 2 {
+		t.Errorf("Expected 2 links loaded after the Put, got %v", got)
+	}
+	if _, err := SoftDelete(context.Background(), store, "/b", "alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(m.links.WithLabelValues("links")); got != 1 {
+		t.Errorf("Expected the tombstone not to count, got %v", got)
+	}
+}
+
+func TestStoreMetrics(t *testing.T) {
+	ctx := context.Background()
+	m := NewMetrics(prometheus.NewRegistry())
+	dir := t.TempDir()
+	bolt, err := OpenBoltStore(filepath.Join(dir, "links.db"), WithMetrics(m), WithSource("bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+	sqlite, err := OpenSQLiteStore(filepath.Join(dir, "links.sqlite"), WithMetrics(m), WithSource("sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	for name, store := range map[string]MutableStore{"bolt": bolt, "sqlite": sqlite} {
+		if got := testutil.ToFloat64(m.loads.WithLabelValues(name, "success")); got != 1 {
+			t.Errorf("%s: expected 1 successful load on opening, got %v", name, got)
+		}
+		store.Put(ctx, Link{Path: "/a", URL: "https://a.com"})
+		store.Put(ctx, Link{Path: "/b", URL: "https://b.com"})
+		SoftDelete(ctx, store, "/b", "alice", "")
+		if got := testutil.ToFloat64(m.links.WithLabelValues(name)); got != 1 {
+			t.Errorf("%s: expected 1 live link, got %v", name, got)
+		}
+	}
+
+	t.Run("Admin counts the links of stores that don't", func(t *testing.T) {
+		admin := NewAdmin(NewMapStore(nil), WithMetrics(m))
+		for _, body := range []string{`{"path": "/a", "url": "https://a.com"}`, `{"path": "/b", "url": "https://b.com"}`} {
+			admin.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/links", strings.NewReader(body)))
+		}
+		admin.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/links/b", nil))
+		if got := testutil.ToFloat64(m.links.WithLabelValues("admin")); got != 1 {
+			t.Errorf("Expected 1 live link, got %v", got)
+		}
+	})
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	store := NewMapStore(map[string]string{"/a": "https://a.com"})
+	if m.InstrumentStore("map", store) != Store(store) {
+		t.Error("Expected nil Metrics to leave the store as is")
+	}
+	handler := MapHandler(map[string]string{"/a": "https://a.com"}, http.HandlerFunc(fallback), WithMetrics(m))
+	assertStatus(t, serve(handler, "/a"), http.StatusFound)
+}
@@ -0,0 +1,54 @@
+package urlshort
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// NDJSON link files have one JSON object per line, in the same
+// shape as the objects of a JSON link file:
+//
+//     {"path": "/a", "url": "https://a.example.com"}
+//     {"path": "/b", "url": "https://b.example.com", "status": 301}
+//
+// Blank lines are ignored.
+
+func init() {
+	RegisterFormat(Format{
+		Name:       "ndjson",
+		Extensions: []string{".ndjson", ".jsonl"},
+		Sniff: func(data []byte) bool {
+			return firstByte(data) == '{'
+		},
+		Decode: parseNDJSON,
+		Encode: func(links []Link) ([]byte, error) {
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			for _, link := range links {
+				if err := enc.Encode(link); err != nil {
+					return nil, err
+				}
+			}
+			return buf.Bytes(), nil
+		},
+	})
+}
+
+func parseNDJSON(data []byte) ([]Entry, error) {
+	var entries []Entry
+	var offset int64
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		start := offset
+		offset += int64(len(line)) + 1
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		e := Entry{Pos: Pos{Line: i + 1, Column: 1, Offset: start}}
+		if err := json.Unmarshal(line, &e.Link); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
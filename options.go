@@ -0,0 +1,128 @@
+package urlshort
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// An Option configures the http.HandlerFunc returned by
+// Handler, MapHandler, YAMLHandler and JSONHandler. Options
+// that affect how links are loaded, such as WithAliasPolicy,
+// are also accepted by LoadFile, NewFileStore, OpenBoltStore,
+// OpenSQLiteStore and NewAdmin, and WithMetrics by all of
+// them. The links gauge counts live links, not tombstones.
+// WithAuditLog only affects NewFileStore.
+type Option func(*options)
+
+type options struct {
+	status  int
+	query   QueryPolicy
+	aliases AliasPolicy
+	clicks  ClickSink
+	metrics *Metrics
+	source  string
+	audit   *AuditLog
+
+	expiryURL string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{status: http.StatusFound, query: QueryDrop, aliases: DefaultAliasPolicy}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithStatus sets the redirect status code used for links
+// that don't specify their own. The default is 302 Found.
+// WithStatus panics if code is not one of 301, 302, 303, 307
+// or 308.
+func WithStatus(code int) Option {
+	if !isRedirectStatus(code) {
+		panic(fmt.Sprintf("urlshort: invalid redirect status %d", code))
+	}
+	return func(o *options) {
+		o.status = code
+	}
+}
+
+// WithQueryPolicy sets what happens to the query string of
+// incoming requests for links that don't set their own
+// policy. The default is QueryDrop. WithQueryPolicy panics if
+// p is not one of the QueryPolicy constants.
+func WithQueryPolicy(p QueryPolicy) Option {
+	if p == "" || !p.valid() {
+		panic(fmt.Sprintf("urlshort: invalid query policy %q", p))
+	}
+	return func(o *options) {
+		o.query = p
+	}
+}
+
+// WithAliasPolicy sets the rules for which paths links may
+// use. The default is DefaultAliasPolicy. Pass the same policy
+// to the loader or Admin and to the Handler serving its links,
+// so that case folding matches on both sides.
+func WithAliasPolicy(p AliasPolicy) Option {
+	return func(o *options) {
+		o.aliases = p
+	}
+}
+
+// sourceName returns the name set with WithSource, or def.
+func (o *options) sourceName(def string) string {
+	if o.source != "" {
+		return o.source
+	}
+	return def
+}
+
+// resolve implements Resolve.
+func (o *options) resolve(store Store, r *http.Request) (Link, string, int, error) {
+	link, err := lookupRequest(r.Context(), store, r, o.aliases.FoldCase)
+	if err != nil {
+		return Link{}, "", 0, err
+	}
+	now := time.Now()
+	switch {
+	case link.Deleted():
+		return link, "", http.StatusGone, ErrDeleted
+	case link.Pending(now):
+		return Link{}, "", 0, fmt.Errorf("%w: %s is not active until %s", ErrNotFound, link.Key(), link.NotBefore.Format(time.RFC3339))
+	case link.Expired(now):
+		if o.expiryURL != "" {
+			return link, o.expiryURL, http.StatusFound, ErrExpired
+		}
+		return link, "", http.StatusGone, ErrExpired
+	}
+	return link, o.destination(link, r), o.redirectStatus(link), nil
+}
+
+// destination returns the URL to redirect r to for link.
+func (o *options) destination(link Link, r *http.Request) string {
+	p := link.Query
+	if p == "" {
+		p = o.query
+	}
+	return p.apply(link.URL, r.URL.RawQuery)
+}
+
+// redirectStatus returns the status code to redirect to link
+// with.
+func (o *options) redirectStatus(link Link) int {
+	if link.Status != 0 {
+		return link.Status
+	}
+	return o.status
+}
+
+func isRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package urlshort
+
+import (
+	"net/url"
+)
+
+// QueryPolicy says what happens to the query string of an
+// incoming request when it is redirected.
+//
+// Fragments are never sent to the server, so there is nothing
+// to preserve on the way in: a fragment in the destination URL
+// is always kept, and browsers carry the original fragment
+// over to destinations that don't have one.
+type QueryPolicy string
+
+const (
+	// QueryDrop discards the incoming query. This is the
+	// default.
+	QueryDrop QueryPolicy = "drop"
+	// QueryAppend adds the incoming query after the
+	// destination's own, keeping repeated keys from both.
+	QueryAppend QueryPolicy = "append"
+	// QueryMergeIncoming merges the two queries, with the
+	// incoming value replacing the destination's for keys
+	// present in both.
+	QueryMergeIncoming QueryPolicy = "merge-incoming"
+	// QueryMergeDestination merges the two queries, with the
+	// destination's value kept for keys present in both.
+	QueryMergeDestination QueryPolicy = "merge-destination"
+)
+
+// valid reports whether p is one of the known policies. The
+// empty policy is valid and means "use the default".
+func (p QueryPolicy) valid() bool {
+	switch p {
+	case "", QueryDrop, QueryAppend, QueryMergeIncoming, QueryMergeDestination:
+		return true
+	}
+	return false
+}
+
+// apply returns dest with the incoming raw query combined
+// according to p. Destinations that can't be parsed are
+// returned unchanged.
+func (p QueryPolicy) apply(dest, rawQuery string) string {
+	if rawQuery == "" || p == "" || p == QueryDrop {
+		return dest
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	switch p {
+	case QueryAppend:
+		if u.RawQuery == "" {
+			u.RawQuery = rawQuery
+		} else {
+			u.RawQuery += "&" + rawQuery
+		}
+	case QueryMergeIncoming, QueryMergeDestination:
+		incoming, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return dest
+		}
+		q := u.Query()
+		for k, v := range incoming {
+			if _, ok := q[k]; ok && p == QueryMergeDestination {
+				continue
+			}
+			q[k] = v
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
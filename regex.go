@@ -0,0 +1,83 @@
+package urlshort
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A regex rule has a match pattern instead of a path, in the
+// style of nginx rewrite rules:
+//
+//     - match: ^/docs/v(\d+)/(.*)$
+//       url: https://docs.example.com/$2?version=$1
+//
+// $1, ${1} or ${name} in the URL are replaced by the matching
+// capture group, escaped the same way as template placeholders
+// (see template.go), and $$ is a literal dollar sign. Rules are
+// tried in the order they are declared, after exact paths
+// miss and before templates and wildcards.
+
+type regexRule struct {
+	re   *regexp.Regexp
+	host string
+	link Link
+}
+
+var groupRefRE = regexp.MustCompile(`\$(\$|[0-9]+|\{[A-Za-z0-9_]+\})`)
+
+// compileRule compiles link.Match and checks that every group
+// referenced by link.URL exists in the pattern.
+func compileRule(link Link) (*regexRule, error) {
+	re, err := regexp.Compile(link.Match)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range groupRefRE.FindAllStringSubmatch(link.URL, -1) {
+		if m[1] != "$" && groupIndex(re, m[1]) < 0 {
+			return nil, fmt.Errorf("url refers to unknown group %s", m[0])
+		}
+	}
+	return &regexRule{re: re, host: canonicalHost(link.Host), link: link}, nil
+}
+
+// groupIndex returns the submatch index for a group reference
+// such as 2, {2} or {name}, or -1 if there is no such group.
+func groupIndex(re *regexp.Regexp, ref string) int {
+	ref = strings.TrimSuffix(strings.TrimPrefix(ref, "{"), "}")
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n > re.NumSubexp() {
+			return -1
+		}
+		return n
+	}
+	return re.SubexpIndex(ref)
+}
+
+// apply returns the rule's link with its URL expanded if path
+// matches the rule.
+func (r *regexRule) apply(path string) (Link, bool) {
+	groups := r.re.FindStringSubmatch(path)
+	if groups == nil {
+		return Link{}, false
+	}
+	link := r.link
+	query := strings.IndexByte(link.URL, '?')
+	var b strings.Builder
+	last := 0
+	for _, m := range groupRefRE.FindAllStringSubmatchIndex(link.URL, -1) {
+		b.WriteString(link.URL[last:m[0]])
+		last = m[1]
+		ref := link.URL[m[2]:m[3]]
+		if ref == "$" {
+			b.WriteByte('$')
+			continue
+		}
+		inQuery := query >= 0 && m[0] > query
+		b.WriteString(escapeValue(groups[groupIndex(r.re, ref)], inQuery))
+	}
+	b.WriteString(link.URL[last:])
+	link.URL = b.String()
+	return link, true
+}
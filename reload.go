@@ -0,0 +1,249 @@
+package urlshort
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FileStore is a Store backed by a link file on disk in any
+// registered format (see LoadFile). The file is validated
+// with the same rules as YAMLHandler and can be reloaded
+// while the server is running: a successful reload
+// atomically swaps in the new links, while a failed one keeps
+// serving the previous good set and logs the error.
+//
+// FileStore is also a MutableStore: Put and Delete validate
+// the whole set of links with the change applied and then
+// rewrite the file with SaveFile, keeping the entries in the
+// order they were loaded and folding new paths as on load.
+//
+// With WithMetrics, every load and reload is counted as a
+// success or failure under the file's path (or the name set
+// with WithSource), and the links gauge follows the file.
+// With WithAuditLog, each is also recorded in the audit log.
+type FileStore struct {
+	// ErrorLog specifies an optional logger for reload
+	// errors. If nil, logging is done via the log package's
+	// standard logger.
+	ErrorLog *log.Logger
+
+	path  string
+	opts  *options
+	links atomic.Pointer[MapStore]
+
+	mu      sync.Mutex // serializes reloads and writes
+	entries []Entry    // the links being served, in file order
+	modTime time.Time
+	size    int64
+}
+
+// NewFileStore loads the link file at path. Unlike Reload, an
+// invalid file is an error here since there is no previous
+// set of links to fall back on.
+func NewFileStore(path string, opts ...Option) (*FileStore, error) {
+	s := &FileStore{path: path, opts: newOptions(opts)}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Lookup returns the link for path from the most recently
+// loaded version of the file.
+func (s *FileStore) Lookup(ctx context.Context, path string) (Link, error) {
+	return s.links.Load().Lookup(ctx, path)
+}
+
+// List returns every link from the most recently loaded
+// version of the file.
+func (s *FileStore) List(ctx context.Context) ([]Link, error) {
+	return s.links.Load().List(ctx)
+}
+
+// Put adds link to the file, replacing the link with the same
+// key, or for a match rule the rule with the same expression.
+func (s *FileStore) Put(ctx context.Context, link Link) error {
+	return s.PutAll(ctx, []Link{link})
+}
+
+// PutAll adds links to the file as Put does, writing it once.
+// If any of them is invalid, or two of them have the same key,
+// the file is left as it was.
+func (s *FileStore) PutAll(ctx context.Context, links []Link) error {
+	if err := validateLinks(links, &s.opts.aliases); err != nil {
+		return fmt.Errorf("urlshort: %s: %w", s.path, err)
+	}
+	return s.update(ctx, func(current []Entry) ([]Entry, error) {
+	next:
+		for _, link := range links {
+			link.Path = s.opts.aliases.fold(link.Path)
+			for i, e := range current {
+				if e.Key() == link.Key() && e.Match == link.Match {
+					current[i].Link = link
+					continue next
+				}
+			}
+			current = append(current, Entry{Link: link})
+		}
+		return current, nil
+	})
+}
+
+// Delete removes the link for key from the file.
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	return s.update(ctx, func(entries []Entry) ([]Entry, error) {
+		for i, e := range entries {
+			if e.Match == "" && e.Key() == key {
+				return append(entries[:i], entries[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	})
+}
+
+// DeleteRule removes the rule with the given pattern for host
+// from the file.
+func (s *FileStore) DeleteRule(ctx context.Context, host, match string) error {
+	return s.update(ctx, func(entries []Entry) ([]Entry, error) {
+		for i, e := range entries {
+			if e.Match == match && canonicalHost(e.Host) == canonicalHost(host) {
+				return append(entries[:i], entries[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: match %s", ErrNotFound, match)
+	})
+}
+
+// update applies change to a copy of the current entries and,
+// if the result is valid, writes it to the file and starts
+// serving it. Entries kept from the file keep their position,
+// so validation errors point at them.
+func (s *FileStore) update(ctx context.Context, change func([]Entry) ([]Entry, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := change(append([]Entry(nil), s.entries...))
+	if err != nil {
+		return err
+	}
+	store, err := buildStore(entries, s.opts)
+	if err != nil {
+		return fmt.Errorf("urlshort: %s: %w", s.path, err)
+	}
+	links := make([]Link, len(entries))
+	for i, e := range entries {
+		links[i] = e.Link
+	}
+	if err := SaveFile(s.path, links); err != nil {
+		return err
+	}
+	// Read the positions of the entries back from the new file.
+	if saved, err := readFile(s.path); err == nil && len(saved) == len(entries) {
+		for i := range entries {
+			entries[i].Pos = saved[i].Pos
+		}
+	}
+	s.links.Store(store)
+	s.entries = entries
+	s.opts.metrics.setLinks(s.opts.sourceName(s.path), liveEntries(entries))
+	if fi, err := os.Stat(s.path); err == nil {
+		s.modTime, s.size = fi.ModTime(), fi.Size()
+	}
+	return nil
+}
+
+// Reload reads and parses the file, and if it is valid
+// replaces the links being served. On error the previous
+// links stay in place.
+func (s *FileStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.reload()
+	s.opts.metrics.loaded(s.opts.sourceName(s.path), n, err)
+	ev := AuditEvent{Action: AuditReload, Source: s.path, Links: n}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	if aerr := s.opts.audit.Record(context.Background(), ev); aerr != nil {
+		s.logf("%v", aerr)
+	}
+	return err
+}
+
+// reload does the work of Reload, returning the number of
+// links loaded. The caller must hold s.mu.
+func (s *FileStore) reload() (int, error) {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := readFile(s.path)
+	if err != nil {
+		return 0, err
+	}
+	store, err := buildStore(entries, s.opts)
+	if err != nil {
+		return 0, fmt.Errorf("urlshort: %s: %w", s.path, err)
+	}
+	s.links.Store(store)
+	s.entries = entries
+	s.modTime, s.size = fi.ModTime(), fi.Size()
+	return liveEntries(entries), nil
+}
+
+// Watch polls the file every interval and reloads it whenever
+// its modification time or size changes. Reload errors are
+// logged and the previous links are kept. Watch blocks until
+// ctx is done, so it is normally run in its own goroutine.
+func (s *FileStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.changed() {
+			continue
+		}
+		if err := s.Reload(); err != nil {
+			s.logf("%v; keeping previous links", err)
+			// Remember the bad version so it isn't retried
+			// on every tick; the next edit will be picked up.
+			s.markSeen()
+		}
+	}
+}
+
+func (s *FileStore) changed() bool {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !fi.ModTime().Equal(s.modTime) || fi.Size() != s.size
+}
+
+func (s *FileStore) markSeen() {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.modTime, s.size = fi.ModTime(), fi.Size()
+	s.mu.Unlock()
+}
+
+func (s *FileStore) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
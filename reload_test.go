@@ -0,0 +1,176 @@
+package urlshort
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.yaml")
+	writeFile(t, path, "- path: /a\n  url: https://a.com\n")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	store.ErrorLog = log.New(&logs, "", 0)
+	assertLookup(t, store, "/a", "https://a.com")
+
+	t.Run("it swaps in a valid file", func(t *testing.T) {
+		writeFile(t, path, "- path: /b\n  url: https://b.com\n")
+		if err := store.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		assertLookup(t, store, "/b", "https://b.com")
+		if _, err := store.Lookup(context.Background(), "/a"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected /a to be gone, got %v", err)
+		}
+	})
+
+	t.Run("it keeps the previous links for an invalid file", func(t *testing.T) {
+		writeFile(t, path, "- path: b\n  url: https://b.com\n")
+		if err := store.Reload(); err == nil {
+			t.Error("Expected an error for a path without a leading slash")
+		}
+		assertLookup(t, store, "/b", "https://b.com")
+	})
+
+	t.Run("it picks up edits while watching", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go store.Watch(ctx, 10*time.Millisecond)
+
+		writeFile(t, path, "- path: /c\n  url: https://c.com\n  extra: [\n")
+		time.Sleep(50 * time.Millisecond)
+		writeFile(t, path, "- path: /c\n  url: https://c.com\n")
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := store.Lookup(ctx, "/c"); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assertLookup(t, store, "/c", "https://c.com")
+		if logs.Len() == 0 {
+			t.Error("Expected the invalid file to be logged")
+		}
+	})
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the watcher sees a new modification time even
+	// on filesystems with coarse timestamps.
+	now := time.Now().Add(time.Duration(len(data)) * time.Second)
+	os.Chtimes(path, now, now)
+}
+
+func assertLookup(t *testing.T, store Store, path, want string) {
+	t.Helper()
+	link, err := store.Lookup(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Lookup(%s): %v", path, err)
+	}
+	if link.URL != want {
+		t.Errorf("Expected %s to map to %s, got %s", path, want, link.URL)
+	}
+}
+
+func TestFileStoreWrites(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.json")
+	writeFile(t, path, `[{"path": "/a", "url": "https://a.com"}]`)
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put(ctx, Link{Path: "/b", URL: "https://b.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, Link{Path: "/a", URL: "https://a.org"}); err != nil {
+		t.Fatal(err)
+	}
+	var errs LinkErrors
+	if err := store.Put(ctx, Link{Path: "/c", URL: "ftp://c.com"}); !errors.As(err, &errs) {
+		t.Errorf("Expected LinkErrors for an invalid link, got %v", err)
+	}
+	if err := store.Delete(ctx, "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "/b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting /b twice, got %v", err)
+	}
+
+	links, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0] != (Link{Path: "/a", URL: "https://a.org"}) {
+		t.Errorf("Expected the file to only have /a -> https://a.org, got %v", links)
+	}
+	if store.changed() {
+		t.Error("Expected the store's own writes not to count as changes")
+	}
+}
+
+func TestFileStoreWritesKeepFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.yaml")
+	writeFile(t, path, `- path: /Zed
+  url: https://z.com
+- host: go.corp
+  path: /x
+  url: https://go.corp/y
+- path: /a
+  url: https://a.com
+`)
+	policy := DefaultAliasPolicy
+	policy.FoldCase = true
+	store, err := NewFileStore(path, WithAliasPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it keeps the order of the file and folds new paths", func(t *testing.T) {
+		if err := store.Put(ctx, Link{Path: "/New", URL: "https://new.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Put(ctx, Link{Path: "/ZED", URL: "https://z.org"}); err != nil {
+			t.Fatal(err)
+		}
+		links, err := LoadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, link := range links {
+			paths = append(paths, link.Path)
+		}
+		if got := strings.Join(paths, " "); got != "/zed /x /a /new" {
+			t.Errorf("Expected /zed /x /a /new, got %s", got)
+		}
+		if links[0].URL != "https://z.org" {
+			t.Errorf("Expected /zed to be replaced in place, got %v", links[0])
+		}
+	})
+
+	t.Run("it reports where the entries are in the file", func(t *testing.T) {
+		err := store.Put(ctx, Link{Host: "go.corp", Path: "/y", URL: "https://go.corp/x"})
+		var errs LinkErrors
+		if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Pos.Line != 3 {
+			t.Errorf("Expected a loop at line 3, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package urlshort
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A CodeStrategy generates the short code for longURL. attempt
+// starts at 0 and is increased each time the previous code
+// turned out to be taken, so strategies that would otherwise
+// return the same code again must use it to vary the result.
+type CodeStrategy func(longURL string, attempt int) string
+
+const base62Digits = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+func base62(n uint64) string {
+	if n == 0 {
+		return "0"
+	}
+	var buf [11]byte
+	i := len(buf)
+	for n > 0 {
+		i--
+		buf[i] = base62Digits[n%62]
+		n /= 62
+	}
+	return string(buf[i:])
+}
+
+// SequentialCodes returns a strategy that hands out the base62
+// encoding of a counter starting at start, moving on to the
+// next number whenever a code is taken. The counter only lives
+// in memory, so when the store already has generated codes,
+// start should be past them (the number of links in the store
+// is a good choice) to avoid a run of collisions.
+func SequentialCodes(start uint64) CodeStrategy {
+	next := start
+	return func(longURL string, attempt int) string {
+		return base62(atomic.AddUint64(&next, 1) - 1)
+	}
+}
+
+// RandomCodes returns a strategy that generates random base62
+// codes of the given length.
+func RandomCodes(length int) CodeStrategy {
+	return func(longURL string, attempt int) string {
+		code := make([]byte, length)
+		max := big.NewInt(62)
+		for i := range code {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				panic("urlshort: reading random bytes: " + err.Error())
+			}
+			code[i] = base62Digits[n.Int64()]
+		}
+		return string(code)
+	}
+}
+
+// HashCodes returns a strategy that derives a base62 code of
+// the given length from the SHA-256 hash of the URL, so the
+// same URL always gets the same code unless it collides with
+// a different URL.
+func HashCodes(length int) CodeStrategy {
+	return func(longURL string, attempt int) string {
+		input := longURL
+		if attempt > 0 {
+			input += "\x00" + strconv.Itoa(attempt)
+		}
+		sum := sha256.Sum256([]byte(input))
+		n := new(big.Int).SetBytes(sum[:])
+		code := make([]byte, length)
+		base, rem := big.NewInt(62), new(big.Int)
+		for i := range code {
+			n.DivMod(n, base, rem)
+			code[i] = base62Digits[rem.Int64()]
+		}
+		return string(code)
+	}
+}
+
+// ParseCodeStrategy returns the strategy described by spec,
+// for choosing one in a flag or setting: "random", "hash" or
+// "sequential", optionally followed by a colon and the code
+// length for random and hash codes (7 by default) or the
+// first number for sequential ones (0 by default):
+//
+//     random:8
+//     hash
+//     sequential:1000
+func ParseCodeStrategy(spec string) (CodeStrategy, error) {
+	name, arg, hasArg := strings.Cut(spec, ":")
+	n := uint64(7)
+	if name == "sequential" {
+		n = 0
+	}
+	if hasArg {
+		var err error
+		if n, err = strconv.ParseUint(arg, 10, 32); err != nil || (n == 0 && name != "sequential") {
+			return nil, fmt.Errorf("urlshort: invalid code strategy %q", spec)
+		}
+	}
+	switch name {
+	case "random":
+		return RandomCodes(int(n)), nil
+	case "hash":
+		return HashCodes(int(n)), nil
+	case "sequential":
+		return SequentialCodes(n), nil
+	}
+	return nil, fmt.Errorf("urlshort: unknown code strategy %q (want random, hash or sequential)", spec)
+}
+
+// ErrNoCode is returned by Shorten when every attempt produced
+// a code that was already taken.
+var ErrNoCode = errors.New("urlshort: no free short code found")
+
+// Shortener creates links with generated paths for long URLs.
+// The zero value generates random 7 character codes directly
+// under the root path.
+//
+// Shorten checks for a free code and then writes it, so
+// callers sharing a store must serialize their calls; Admin
+// does this for /api/shorten.
+type Shortener struct {
+	// Codes generates the codes. If nil, RandomCodes(7) is
+	// used.
+	Codes CodeStrategy
+	// Prefix is put in front of every code to make the path,
+	// such as "/s/". If empty, "/" is used.
+	Prefix string
+	// MaxAttempts is how many codes to try before giving up
+	// with ErrNoCode. If zero, 10 is used.
+	MaxAttempts int
+	// Aliases is the policy generated paths must follow;
+	// codes it rejects are skipped like taken ones. If nil,
+	// DefaultAliasPolicy is used.
+	Aliases *AliasPolicy
+	// Validate checks each new link before it is stored, and
+	// Shorten fails with its error. If nil, the link is
+	// checked on its own with the rules of YAMLHandler; Admin
+	// also checks it for redirect loops with the links in its
+	// store.
+	Validate func(ctx context.Context, link Link) error
+}
+
+var defaultCodes = RandomCodes(7)
+
+// Shorten returns a link in store redirecting to longURL under
+// a newly generated path, scoped to host unless host is empty.
+// If a generated path already redirects to longURL and hasn't
+// expired, that link is returned instead of creating a new
+// one, and created is false.
+func (s *Shortener) Shorten(ctx context.Context, store MutableStore, host, longURL string) (link Link, created bool, err error) {
+	if err := checkURL(longURL); err != nil {
+		return Link{}, false, LinkErrors{{Err: err}}
+	}
+	codes, prefix, attempts, aliases := s.Codes, s.Prefix, s.MaxAttempts, s.Aliases
+	if codes == nil {
+		codes = defaultCodes
+	}
+	if prefix == "" {
+		prefix = "/"
+	}
+	if attempts == 0 {
+		attempts = 10
+	}
+	if aliases == nil {
+		aliases = &DefaultAliasPolicy
+	}
+	validate := s.Validate
+	if validate == nil {
+		validate = func(ctx context.Context, link Link) error {
+			return validateEntries([]Entry{{Link: link}}, aliases)
+		}
+	}
+	host = canonicalHost(host)
+	for attempt := 0; attempt < attempts; attempt++ {
+		link := Link{Host: host, Path: aliases.fold(prefix + codes(longURL, attempt)), URL: longURL}
+		if aliases.Check(link.Path) != nil {
+			continue
+		}
+		existing, err := store.Lookup(ctx, link.Key())
+		switch {
+		case err == nil && existing.Key() == link.Key() && existing.URL == longURL && !existing.Deleted() && !existing.Expired(time.Now()):
+			return existing, false, nil
+		case err == nil:
+			// Taken, either by another link, a tombstone
+			// (so an old short link never comes back
+			// pointing somewhere new), an expired link that
+			// no longer redirects or by a route that a new
+			// exact link would shadow.
+			continue
+		case !errors.Is(err, ErrNotFound):
+			return Link{}, false, err
+		}
+		if err := validate(ctx, link); err != nil {
+			return Link{}, false, err
+		}
+		if err := store.Put(ctx, link); err != nil {
+			if errors.Is(err, ErrConflict) {
+				continue
+			}
+			return Link{}, false, err
+		}
+		return link, true, nil
+	}
+	return Link{}, false, fmt.Errorf("%w after %d attempts", ErrNoCode, attempts)
+}
@@ -0,0 +1,411 @@
+package urlshort
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "modernc.org/sqlite" // pure Go, no cgo
+)
+
+// SQLiteStore is a MutableStore and Lister that keeps links in
+// a SQLite database. Unlike a Bolt database, the file can be
+// opened with the sqlite3 shell or any other SQLite client
+// while the server is running, since it is written in WAL
+// mode: readers don't block the server and the server doesn't
+// block them.
+//
+// Exact links are rows of the links table, indexed by key,
+// path and URL, and looked up with a prepared statement. Match
+// rules are rows of the rules table, tried in order of id.
+// Templates, wildcards and rules are indexed in memory when
+// the store is opened, so links of those kinds added by other
+// clients are only picked up when it is opened again.
+type SQLiteStore struct {
+	db   *sql.DB
+	path string
+	opts *options
+
+	lookup, putLink, putRule, deleteLink, deleteRule *sql.Stmt
+
+	mu       sync.Mutex // serializes writes with updates to patterns
+	patterns atomic.Pointer[MapStore]
+}
+
+// sqliteMigrations are applied in order, each in its own
+// transaction, to bring a database up to the current schema.
+// The schema_migrations table records which have been applied;
+// never edit a migration that has been released, add a new
+// one instead.
+var sqliteMigrations = []string{
+	`CREATE TABLE links (
+		link_key TEXT PRIMARY KEY,
+		host     TEXT NOT NULL DEFAULT '',
+		path     TEXT NOT NULL,
+		url      TEXT NOT NULL,
+		status   INTEGER NOT NULL DEFAULT 0,
+		query    TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX links_path ON links (path);
+	CREATE INDEX links_url ON links (url);
+	CREATE TABLE rules (
+		id       INTEGER PRIMARY KEY AUTOINCREMENT,
+		link_key TEXT NOT NULL,
+		host     TEXT NOT NULL DEFAULT '',
+		match    TEXT NOT NULL,
+		url      TEXT NOT NULL,
+		status   INTEGER NOT NULL DEFAULT 0,
+		query    TEXT NOT NULL DEFAULT '',
+		UNIQUE (link_key, match)
+	);
+	CREATE INDEX rules_url ON rules (url);`,
+	`ALTER TABLE links ADD COLUMN not_before TEXT;
+	ALTER TABLE links ADD COLUMN expires_at TEXT;
+	ALTER TABLE rules ADD COLUMN not_before TEXT;
+	ALTER TABLE rules ADD COLUMN expires_at TEXT;`,
+	`ALTER TABLE links ADD COLUMN deleted_at TEXT;
+	ALTER TABLE links ADD COLUMN deleted_by TEXT NOT NULL DEFAULT '';
+	ALTER TABLE links ADD COLUMN delete_reason TEXT NOT NULL DEFAULT '';`,
+}
+
+const sqliteLinkColumns = `host, path, url, status, query, not_before, expires_at, deleted_at, deleted_by, delete_reason`
+
+// OpenSQLiteStore opens the SQLite database at path, creating
+// it if needed and applying any pending migrations. Paths are
+// checked against the alias policy set with WithAliasPolicy
+// when links are added. With WithMetrics, the links gauge is
+// set on opening and after every change, under the name set
+// with WithSource or else path.
+func OpenSQLiteStore(path string, opts ...Option) (*SQLiteStore, error) {
+	dsn := (&url.URL{
+		Scheme:   "file",
+		Opaque:   path,
+		RawQuery: "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)",
+	}).String()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("urlshort: opening %s: %w", path, err)
+	}
+	s := &SQLiteStore{db: db, path: path, opts: newOptions(opts)}
+	if err := s.init(context.Background()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("urlshort: %s: %w", path, err)
+	}
+	s.opts.metrics.loadedStore(context.Background(), s.opts.sourceName(path), s)
+	return s, nil
+}
+
+func (s *SQLiteStore) init(ctx context.Context) error {
+	if err := migrateSQLite(ctx, s.db); err != nil {
+		return err
+	}
+	for _, p := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.lookup, `SELECT ` + sqliteLinkColumns + ` FROM links WHERE link_key = ?`},
+		{&s.putLink, `INSERT INTO links (link_key, ` + sqliteLinkColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (link_key) DO UPDATE SET host = excluded.host, path = excluded.path,
+				url = excluded.url, status = excluded.status, query = excluded.query,
+				not_before = excluded.not_before, expires_at = excluded.expires_at,
+				deleted_at = excluded.deleted_at, deleted_by = excluded.deleted_by,
+				delete_reason = excluded.delete_reason`},
+		{&s.putRule, `INSERT INTO rules (link_key, host, match, url, status, query, not_before, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (link_key, match) DO UPDATE SET host = excluded.host,
+				url = excluded.url, status = excluded.status, query = excluded.query,
+				not_before = excluded.not_before, expires_at = excluded.expires_at`},
+		{&s.deleteLink, `DELETE FROM links WHERE link_key = ?`},
+		{&s.deleteRule, `DELETE FROM rules WHERE link_key = ? AND match = ?`},
+	} {
+		stmt, err := s.db.PrepareContext(ctx, p.query)
+		if err != nil {
+			return err
+		}
+		*p.stmt = stmt
+	}
+	return s.loadPatterns(ctx)
+}
+
+// Close closes the prepared statements and the database.
+func (s *SQLiteStore) Close() error {
+	for _, stmt := range []*sql.Stmt{s.lookup, s.putLink, s.putRule, s.deleteLink, s.deleteRule} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return s.db.Close()
+}
+
+// Lookup returns the link stored for key, or failing that the
+// first rule, template or wildcard matching it, in the same
+// order as MapStore.
+func (s *SQLiteStore) Lookup(ctx context.Context, key string) (Link, error) {
+	link, err := scanLink(s.lookup.QueryRowContext(ctx, key))
+	switch {
+	case err == nil:
+		return link, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return Link{}, fmt.Errorf("urlshort: reading %s: %w", key, err)
+	}
+	return s.patterns.Load().Lookup(ctx, key)
+}
+
+// Put validates link and creates or replaces it. A link with a
+// Match pattern replaces the rule with the same pattern and
+// host, or is added after all existing rules.
+func (s *SQLiteStore) Put(ctx context.Context, link Link) error {
+	return s.PutAll(ctx, []Link{link})
+}
+
+// PutAll validates links together and puts them as Put does,
+// all in a single transaction.
+func (s *SQLiteStore) PutAll(ctx context.Context, links []Link) error {
+	if err := validateLinks(links, &s.opts.aliases); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Check for ambiguous templates before writing, but only
+	// serve the new patterns once they are written.
+	patterns, err := withPatterns(ctx, s.patterns.Load(), links)
+	if err != nil {
+		return err
+	}
+	if err := s.putAll(ctx, links); err != nil {
+		return err
+	}
+	if patterns != nil {
+		s.patterns.Store(patterns)
+	}
+	s.opts.metrics.recount(ctx, s.opts.sourceName(s.path), s)
+	return nil
+}
+
+func (s *SQLiteStore) putAll(ctx context.Context, links []Link) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("urlshort: writing links: %w", err)
+	}
+	defer tx.Rollback()
+	putLink, putRule := tx.StmtContext(ctx, s.putLink), tx.StmtContext(ctx, s.putRule)
+	for _, link := range links {
+		notBefore, expiresAt := sqliteTime(link.NotBefore), sqliteTime(link.ExpiresAt)
+		if link.Match == "" {
+			_, err = putLink.ExecContext(ctx, link.Key(), link.Host, link.Path, link.URL, link.Status, string(link.Query), notBefore, expiresAt,
+				sqliteTime(link.DeletedAt), link.DeletedBy, link.DeleteReason)
+		} else {
+			_, err = putRule.ExecContext(ctx, link.Key(), link.Host, link.Match, link.URL, link.Status, string(link.Query), notBefore, expiresAt)
+		}
+		if err != nil {
+			return fmt.Errorf("urlshort: writing %s: %w", link.Key(), err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("urlshort: writing links: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the link for key.
+func (s *SQLiteStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, err := s.deleteLink.ExecContext(ctx, key)
+	if err != nil {
+		return fmt.Errorf("urlshort: deleting %s: %w", key, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	// Drop it from the pattern index too, if it was there.
+	s.patterns.Load().Delete(ctx, key)
+	s.opts.metrics.recount(ctx, s.opts.sourceName(s.path), s)
+	return nil
+}
+
+// DeleteRule removes the rule with the given pattern for host.
+func (s *SQLiteStore) DeleteRule(ctx context.Context, host, match string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	patterns := copyPatterns(ctx, s.patterns.Load())
+	if err := patterns.DeleteRule(ctx, host, match); err != nil {
+		return err
+	}
+	res, err := s.deleteRule.ExecContext(ctx, canonicalHost(host), match)
+	if err != nil {
+		return fmt.Errorf("urlshort: deleting match %s: %w", match, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: match %s", ErrNotFound, match)
+	}
+	s.patterns.Store(patterns)
+	s.opts.metrics.recount(ctx, s.opts.sourceName(s.path), s)
+	return nil
+}
+
+// List returns every link sorted by key, followed by the match
+// rules in the order they are tried.
+func (s *SQLiteStore) List(ctx context.Context) ([]Link, error) {
+	links, err := s.query(ctx, `SELECT `+sqliteLinkColumns+` FROM links ORDER BY link_key`)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := s.queryRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append(links, rules...), nil
+}
+
+func (s *SQLiteStore) query(ctx context.Context, query string, args ...interface{}) ([]Link, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("urlshort: listing links: %w", err)
+	}
+	defer rows.Close()
+	var links []Link
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("urlshort: listing links: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("urlshort: listing links: %w", err)
+	}
+	return links, nil
+}
+
+func (s *SQLiteStore) queryRules(ctx context.Context) ([]Link, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT host, match, url, status, query, not_before, expires_at FROM rules ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("urlshort: listing rules: %w", err)
+	}
+	defer rows.Close()
+	var rules []Link
+	for rows.Next() {
+		var link Link
+		var notBefore, expiresAt sql.NullString
+		err := rows.Scan(&link.Host, &link.Match, &link.URL, &link.Status, &link.Query, &notBefore, &expiresAt)
+		if err == nil {
+			err = scanTimes(map[**time.Time]sql.NullString{&link.NotBefore: notBefore, &link.ExpiresAt: expiresAt})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("urlshort: listing rules: %w", err)
+		}
+		rules = append(rules, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("urlshort: listing rules: %w", err)
+	}
+	return rules, nil
+}
+
+// loadPatterns indexes the templates, wildcards and rules in
+// the database.
+func (s *SQLiteStore) loadPatterns(ctx context.Context) error {
+	links, err := s.query(ctx, `SELECT `+sqliteLinkColumns+` FROM links
+		WHERE path LIKE '%{%' OR path LIKE '%}%' OR path LIKE '%/*'`)
+	if err != nil {
+		return err
+	}
+	rules, err := s.queryRules(ctx)
+	if err != nil {
+		return err
+	}
+	patterns := NewMapStore(nil)
+	for _, link := range append(links, rules...) {
+		if err := patterns.Put(ctx, link); err != nil {
+			return err
+		}
+	}
+	s.patterns.Store(patterns)
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanLink(row rowScanner) (Link, error) {
+	var link Link
+	var notBefore, expiresAt, deletedAt sql.NullString
+	err := row.Scan(&link.Host, &link.Path, &link.URL, &link.Status, &link.Query, &notBefore, &expiresAt,
+		&deletedAt, &link.DeletedBy, &link.DeleteReason)
+	if err != nil {
+		return Link{}, err
+	}
+	return link, scanTimes(map[**time.Time]sql.NullString{
+		&link.NotBefore: notBefore,
+		&link.ExpiresAt: expiresAt,
+		&link.DeletedAt: deletedAt,
+	})
+}
+
+// Times are stored as RFC 3339 text in UTC, or NULL if unset.
+
+func sqliteTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// scanTimes parses the time columns in src into the fields
+// they are keyed by.
+func scanTimes(src map[**time.Time]sql.NullString) error {
+	for dst, v := range src {
+		if !v.Valid {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, v.String)
+		if err != nil {
+			return fmt.Errorf("invalid time %q: %w", v.String, err)
+		}
+		*dst = &t
+	}
+	return nil
+}
+
+// migrateSQLite applies the migrations db hasn't seen yet.
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("schema version %d is newer than the supported version %d", version, len(sqliteMigrations))
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrating to schema version %d: %w", version+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
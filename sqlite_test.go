@@ -0,0 +1,82 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.sqlite")
+	store, err := OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := []Link{
+		{Path: "/a", URL: "https://a.com"},
+		{Host: "go.corp", Path: "/a", URL: "https://intranet.example.com/a", Status: 301},
+		{Path: "/gh/*", URL: "https://github.com/*"},
+		{Path: "/jira/{id}", URL: "https://jira.example.com/browse/{id}", Query: QueryAppend},
+		{Match: `^/v(\d+)$`, URL: "https://example.com/version/$1"},
+		{Match: `^/w(\d+)$`, URL: "https://example.com/week/$1"},
+	}
+	for _, link := range links {
+		if err := store.Put(ctx, link); err != nil {
+			t.Fatalf("Put(%v): %v", link, err)
+		}
+	}
+	// Replacing a rule keeps its place in the order.
+	links[4].Status = 308
+	if err := store.Put(ctx, links[4]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, Link{Path: "/jira/{key}", URL: "https://jira.example.com/{key}"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict for an ambiguous template, got %v", err)
+	}
+	if err := store.Delete(ctx, "/nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting a missing link, got %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	assertLookup(t, store, "/a", "https://a.com")
+	assertLookup(t, store, "go.corp/a", "https://intranet.example.com/a")
+	assertLookup(t, store, "/gh/gophercises", "https://github.com/gophercises")
+	assertLookup(t, store, "/jira/ABC-1", "https://jira.example.com/browse/ABC-1")
+	assertLookup(t, store, "/v2", "https://example.com/version/2")
+
+	if err := store.Delete(ctx, "/gh/*"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lookup(ctx, "/gh/gophercises"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the wildcard to be gone, got %v", err)
+	}
+	got, err := store.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Link{links[0], links[3], links[1], links[4], links[5]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	start, end := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	timed := Link{Path: "/sale", URL: "https://a.com", NotBefore: &start, ExpiresAt: &end}
+	if err := store.Put(ctx, timed); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Lookup(ctx, "/sale"); err != nil || !reflect.DeepEqual(got, timed) {
+		t.Errorf("Lookup(/sale) = %v, %v, want %v", got, err, timed)
+	}
+}
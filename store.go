@@ -0,0 +1,324 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when it has no link for
+// the requested path. Handler treats it as a signal to call
+// the fallback http.Handler rather than as a failure.
+var ErrNotFound = errors.New("urlshort: link not found")
+
+// ErrConflict is returned (possibly wrapped) when a link can't
+// be added because it clashes with one already in a store.
+var ErrConflict = errors.New("urlshort: conflicting link")
+
+// Link is a single short path and the URL it redirects to.
+// Instead of a Path, a link may have a Match regular
+// expression; see the comment in regex.go. A link with a Host
+// only applies to requests for that host; see host.go. Status
+// is the redirect status code to use and Query the policy for
+// the incoming query string; their zero values mean the
+// handler defaults.
+type Link struct {
+	Host   string      `yaml:"host,omitempty" json:"host,omitempty" toml:"host,omitempty"`
+	Path   string      `yaml:"path,omitempty" json:"path,omitempty" toml:"path,omitempty"`
+	Match  string      `yaml:"match,omitempty" json:"match,omitempty" toml:"match,omitempty"`
+	URL    string      `yaml:"url" json:"url" toml:"url"`
+	Status int         `yaml:"status,omitempty" json:"status,omitempty" toml:"status,omitzero"`
+	Query  QueryPolicy `yaml:"query,omitempty" json:"query,omitempty" toml:"query,omitempty"`
+
+	// NotBefore and ExpiresAt, if set, limit when the link
+	// works; see expiry.go.
+	NotBefore *time.Time `yaml:"not_before,omitempty" json:"not_before,omitempty" toml:"not_before,omitempty"`
+	ExpiresAt *time.Time `yaml:"expires_at,omitempty" json:"expires_at,omitempty" toml:"expires_at,omitempty"`
+
+	// DeletedAt, DeletedBy and DeleteReason are set on links
+	// that have been soft deleted; see tombstone.go.
+	DeletedAt    *time.Time `yaml:"deleted_at,omitempty" json:"deleted_at,omitempty" toml:"deleted_at,omitempty"`
+	DeletedBy    string     `yaml:"deleted_by,omitempty" json:"deleted_by,omitempty" toml:"deleted_by,omitempty"`
+	DeleteReason string     `yaml:"delete_reason,omitempty" json:"delete_reason,omitempty" toml:"delete_reason,omitempty"`
+}
+
+// Store is anything that can resolve a key to a Link. A key
+// is a request path, or for host-scoped links a host followed
+// by a path (see Link.Key). Implementations must return
+// ErrNotFound (or an error wrapping it) for unknown keys; any
+// other error is treated as a backend failure.
+type Store interface {
+	Lookup(ctx context.Context, key string) (Link, error)
+}
+
+// MutableStore is a Store whose links can be changed at
+// runtime. Put creates or replaces the link for link.Key().
+// Deleting a key that doesn't exist returns ErrNotFound.
+type MutableStore interface {
+	Store
+	Put(ctx context.Context, link Link) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Lister is implemented by stores that can enumerate every
+// link they hold. Links are returned sorted by key.
+type Lister interface {
+	List(ctx context.Context) ([]Link, error)
+}
+
+// RuleDeleter is implemented by stores that can delete match
+// rules, which Delete can't address since a rule is stored
+// under its host rather than a key of its own. DeleteRule
+// removes the rule with the given pattern for host (empty for
+// every host), or returns ErrNotFound if there is none. Rules
+// can't be soft deleted.
+type RuleDeleter interface {
+	DeleteRule(ctx context.Context, host, match string) error
+}
+
+// DeleteRule deletes a match rule from store, which must be a
+// RuleDeleter.
+func DeleteRule(ctx context.Context, store MutableStore, host, match string) error {
+	deleter, ok := store.(RuleDeleter)
+	if !ok {
+		return errors.New("urlshort: store can't delete match rules")
+	}
+	return deleter.DeleteRule(ctx, host, match)
+}
+
+// BulkStore is implemented by stores that can put many links
+// at once, so that if PutAll fails none of them are stored.
+type BulkStore interface {
+	PutAll(ctx context.Context, links []Link) error
+}
+
+// PutAll puts links in store in order, all at once if store is
+// a BulkStore. Otherwise it puts them one at a time and stops
+// at the first error, leaving the links before it stored.
+func PutAll(ctx context.Context, store MutableStore, links []Link) error {
+	if bulk, ok := store.(BulkStore); ok {
+		return bulk.PutAll(ctx, links)
+	}
+	for _, link := range links {
+		if err := store.Put(ctx, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoreFunc adapts an ordinary function to the Store
+// interface, which is handy for one-off lookups in tests or
+// for wrapping an existing lookup function.
+type StoreFunc func(ctx context.Context, key string) (Link, error)
+
+// Lookup calls f(ctx, key).
+func (f StoreFunc) Lookup(ctx context.Context, key string) (Link, error) {
+	return f(ctx, key)
+}
+
+// MapStore is an in-memory MutableStore. The zero value is
+// not usable; create one with NewMapStore. It is safe for
+// concurrent use.
+//
+// Paths ending in "/*" are wildcard routes that match every
+// path under their prefix, and paths with placeholders such
+// as /jira/{id} are templates; see the comments in wildcard.go
+// and template.go. Links with a Match pattern are kept in the
+// order they were added; see regex.go.
+type MapStore struct {
+	mu        sync.RWMutex
+	links     map[string]Link // by key
+	rules     []*regexRule
+	templates []*pathTemplate
+	wildcards []string // wildcard keys, longest prefix first
+}
+
+// NewMapStore returns a MapStore seeded with the given paths
+// and the URLs they map to. The map is copied, so later
+// changes to pathsToUrls are not seen by the store.
+func NewMapStore(pathsToUrls map[string]string) *MapStore {
+	s := &MapStore{links: make(map[string]Link, len(pathsToUrls))}
+	for path, url := range pathsToUrls {
+		s.links[path] = Link{Path: path, URL: url}
+	}
+	s.reindex()
+	return s
+}
+
+// Lookup returns the link stored for key, or failing that
+// the link of the first regex rule, template or longest
+// wildcard route for the same host matching the key's path,
+// with its URL expanded.
+func (s *MapStore) Lookup(ctx context.Context, key string) (Link, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if link, ok := s.links[key]; ok {
+		return link, nil
+	}
+	host, path := splitKey(key)
+	for _, r := range s.rules {
+		if r.host != host {
+			continue
+		}
+		if link, ok := r.apply(path); ok {
+			return link, nil
+		}
+	}
+	for _, t := range s.templates {
+		if t.host != host {
+			continue
+		}
+		if values, ok := t.match(path); ok {
+			return t.expand(s.links[t.key], values), nil
+		}
+	}
+	for _, pattern := range s.wildcards {
+		if suffix, ok := matchWildcard(pattern, key); ok {
+			return expandWildcard(s.links[pattern], suffix), nil
+		}
+	}
+	return Link{}, ErrNotFound
+}
+
+// Put creates or replaces the link for link.Key(). Template
+// links that are invalid or could match the same paths as an
+// existing template are rejected. A link with a Match pattern
+// replaces the rule with the same pattern and host, or is
+// added after all existing rules.
+func (s *MapStore) Put(ctx context.Context, link Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if link.Match != "" {
+		return s.putRule(link)
+	}
+	if isTemplate(link.Path) {
+		t, err := compileTemplate(link)
+		if err != nil {
+			return fmt.Errorf("urlshort: path %s: %w", link.Path, err)
+		}
+		for _, u := range s.templates {
+			if u.key != t.key && t.overlaps(u) {
+				return fmt.Errorf("%w: path %s is ambiguous with %s", ErrConflict, t.key, u.key)
+			}
+		}
+	}
+	s.links[link.Key()] = link
+	s.reindex()
+	return nil
+}
+
+// Delete removes the link for key.
+func (s *MapStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.links[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.links, key)
+	s.reindex()
+	return nil
+}
+
+// DeleteRule removes the rule with the given pattern for host.
+func (s *MapStore) DeleteRule(ctx context.Context, host, match string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	host = canonicalHost(host)
+	for i, r := range s.rules {
+		if r.link.Match == match && r.host == host {
+			s.rules = append(s.rules[:i:i], s.rules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: match %s", ErrNotFound, match)
+}
+
+// List returns every link in the store sorted by key,
+// followed by the regex rules in the order they are tried.
+func (s *MapStore) List(ctx context.Context) ([]Link, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	links := make([]Link, 0, len(s.links)+len(s.rules))
+	for _, link := range s.links {
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Key() < links[j].Key() })
+	for _, r := range s.rules {
+		links = append(links, r.link)
+	}
+	return links, nil
+}
+
+func (s *MapStore) putRule(link Link) error {
+	r, err := compileRule(link)
+	if err != nil {
+		return fmt.Errorf("urlshort: match %s: %w", link.Match, err)
+	}
+	for i, old := range s.rules {
+		if old.link.Match == link.Match && old.host == r.host {
+			s.rules[i] = r
+			return nil
+		}
+	}
+	s.rules = append(s.rules, r)
+	return nil
+}
+
+// isPattern reports whether link is matched by pattern rather
+// than by looking up its key: a regex rule, template or
+// wildcard route.
+func isPattern(link Link) bool {
+	return link.Match != "" || isTemplate(link.Path) || isWildcard(link.Path)
+}
+
+// withPatterns returns a copy of the pattern index patterns
+// with the wildcards, templates and rules among links added,
+// failing if a template is ambiguous with another, or nil if
+// links has none, so that the index can be swapped in once
+// links are written.
+func withPatterns(ctx context.Context, patterns *MapStore, links []Link) (*MapStore, error) {
+	var next *MapStore
+	for _, link := range links {
+		if !isPattern(link) {
+			continue
+		}
+		if next == nil {
+			next = copyPatterns(ctx, patterns)
+		}
+		if err := next.Put(ctx, link); err != nil {
+			return nil, err
+		}
+	}
+	return next, nil
+}
+
+// copyPatterns returns a copy of the pattern index patterns.
+func copyPatterns(ctx context.Context, patterns *MapStore) *MapStore {
+	links, _ := patterns.List(ctx)
+	next := NewMapStore(nil)
+	for _, link := range links {
+		next.Put(ctx, link) // already checked
+	}
+	return next
+}
+
+// reindex rebuilds the template and wildcard routes. The
+// caller must hold s.mu for writing.
+func (s *MapStore) reindex() {
+	s.templates, s.wildcards = s.templates[:0], s.wildcards[:0]
+	for key, link := range s.links {
+		switch {
+		case isTemplate(link.Path):
+			if t, err := compileTemplate(link); err == nil {
+				s.templates = append(s.templates, t)
+			}
+		case isWildcard(link.Path):
+			s.wildcards = append(s.wildcards, key)
+		}
+	}
+	sortWildcards(s.wildcards)
+}
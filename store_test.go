@@ -0,0 +1,141 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestPutAll(t *testing.T) {
+	ctx := context.Background()
+	openFile := func(t *testing.T) MutableStore {
+		path := filepath.Join(t.TempDir(), "links.yaml")
+		writeFile(t, path, "- path: /a\n  url: https://a.com\n")
+		store, err := NewFileStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	stores := map[string]func(t *testing.T) MutableStore{
+		"file": openFile,
+		"bolt": func(t *testing.T) MutableStore {
+			store, err := OpenBoltStore(filepath.Join(t.TempDir(), "links.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			store.Put(ctx, Link{Path: "/a", URL: "https://a.com"})
+			return store
+		},
+		"sqlite": func(t *testing.T) MutableStore {
+			store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "links.sqlite"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			store.Put(ctx, Link{Path: "/a", URL: "https://a.com"})
+			return store
+		},
+		"recording": func(t *testing.T) MutableStore {
+			return NewRecordingStore(openFile(t), NewMemoryHistory(), nil)
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := open(t)
+			if _, ok := store.(BulkStore); !ok {
+				t.Fatalf("Expected %T to be a BulkStore", store)
+			}
+
+			err := PutAll(ctx, store, []Link{
+				{Path: "/x", URL: "https://x.com"},
+				{Path: "/jira/{id}", URL: "https://jira.example.com/browse/{id}"},
+				{Path: "/jira/{key}", URL: "https://jira.example.com/{key}"},
+			})
+			if err == nil {
+				t.Error("Expected an error for an ambiguous template")
+			}
+			var errs LinkErrors
+			err = PutAll(ctx, store, []Link{
+				{Path: "/x", URL: "https://x.com"},
+				{Path: "/x", URL: "https://x.org"},
+			})
+			if !errors.As(err, &errs) {
+				t.Errorf("Expected LinkErrors for a duplicate key, got %v", err)
+			}
+			for _, key := range []string{"/x", "/jira/ABC-1"} {
+				if _, err := store.Lookup(ctx, key); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Expected nothing to be stored for %s, got %v", key, err)
+				}
+			}
+
+			err = PutAll(ctx, store, []Link{
+				{Path: "/a", URL: "https://a.org"},
+				{Path: "/x", URL: "https://x.com"},
+				{Path: "/jira/{id}", URL: "https://jira.example.com/browse/{id}"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertLookup(t, store, "/a", "https://a.org")
+			assertLookup(t, store, "/x", "https://x.com")
+			assertLookup(t, store, "/jira/ABC-1", "https://jira.example.com/browse/ABC-1")
+		})
+	}
+}
+
+func TestDeleteRule(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "links.yaml")
+	writeFile(t, path, "- path: /a\n  url: https://a.com\n")
+	file, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bolt, err := OpenBoltStore(filepath.Join(dir, "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+	sqlite, err := OpenSQLiteStore(filepath.Join(dir, "links.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	history := NewMemoryHistory()
+	stores := map[string]MutableStore{
+		"map":       NewMapStore(nil),
+		"file":      file,
+		"bolt":      bolt,
+		"sqlite":    sqlite,
+		"recording": NewRecordingStore(NewMapStore(nil), history, nil),
+	}
+
+	for name, store := range stores {
+		rules := []Link{
+			{Match: `^/v(\d+)$`, URL: "https://example.com/version/$1"},
+			{Host: "go.corp", Match: `^/v(\d+)$`, URL: "https://intranet.example.com/version/$1"},
+		}
+		for _, rule := range rules {
+			if err := store.Put(ctx, rule); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if err := DeleteRule(ctx, store, "Go.Corp", `^/v(\d+)$`); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := store.Lookup(ctx, "go.corp/v2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected the go.corp rule to be gone, got %v", name, err)
+		}
+		assertLookup(t, store, "/v2", "https://example.com/version/2")
+		if err := DeleteRule(ctx, store, "go.corp", `^/v(\d+)$`); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound deleting the rule twice, got %v", name, err)
+		}
+	}
+	if revs, _ := history.Revisions(ctx, "go.corp"); len(revs) != 2 || revs[1].Op != OpPurge || revs[1].Old == nil {
+		t.Errorf("Expected the deletion to be recorded, got %v", revs)
+	}
+}
@@ -0,0 +1,212 @@
+package urlshort
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A template link has named placeholders in its path, each
+// taking up a whole path segment, which are substituted into
+// the destination URL:
+//
+//     - path: /jira/{id}
+//       url: https://jira.example.com/browse/{id}
+//     - path: /pr/{num:int}
+//       url: https://github.com/org/repo/pull/{num}
+//
+// A placeholder may be given a type after a colon:
+//
+//     (none)  any single path segment
+//     int     one or more decimal digits
+//     slug    letters, digits, '-' and '_'
+//     path    the rest of the path, one or more segments; it
+//             must be the last placeholder in the path
+//
+// Substituted values are escaped for where they end up in the
+// URL: path escaping before any '?', query escaping after it.
+//
+// Exact paths win over templates and templates win over
+// wildcards. Two templates that could match the same request
+// path are rejected when they are loaded, so the order of
+// entries in a file never decides which one is used.
+
+type placeholderKind int
+
+const (
+	literalSegment placeholderKind = iota
+	anySegment
+	intSegment
+	slugSegment
+	restSegment
+)
+
+var (
+	intRE  = regexp.MustCompile(`^[0-9]+$`)
+	slugRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	nameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+type segment struct {
+	kind  placeholderKind
+	value string // the literal text, or the placeholder name
+}
+
+func (s segment) matches(v string) bool {
+	switch s.kind {
+	case literalSegment:
+		return v == s.value
+	case intSegment:
+		return intRE.MatchString(v)
+	case slugSegment:
+		return slugRE.MatchString(v)
+	}
+	return v != ""
+}
+
+type pathTemplate struct {
+	key      string // the link's key, for error messages and lookups
+	host     string
+	segments []segment
+}
+
+// isTemplate reports whether path has any placeholders.
+func isTemplate(path string) bool {
+	return strings.ContainsAny(path, "{}")
+}
+
+// compileTemplate parses the placeholders in link.Path and
+// checks that every placeholder used in link.URL is defined.
+func compileTemplate(link Link) (*pathTemplate, error) {
+	t := &pathTemplate{key: link.Key(), host: canonicalHost(link.Host)}
+	names := make(map[string]bool)
+	parts := strings.Split(strings.TrimPrefix(link.Path, "/"), "/")
+	for i, part := range parts {
+		if !strings.ContainsAny(part, "{}") {
+			t.segments = append(t.segments, segment{kind: literalSegment, value: part})
+			continue
+		}
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			return nil, fmt.Errorf("placeholder %q must be a whole path segment", part)
+		}
+		name, typ, _ := strings.Cut(part[1:len(part)-1], ":")
+		if !nameRE.MatchString(name) {
+			return nil, fmt.Errorf("invalid placeholder name %q", name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("placeholder {%s} used twice", name)
+		}
+		names[name] = true
+		seg := segment{value: name}
+		switch typ {
+		case "":
+			seg.kind = anySegment
+		case "int":
+			seg.kind = intSegment
+		case "slug":
+			seg.kind = slugSegment
+		case "path":
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("path placeholder {%s} must be last", name)
+			}
+			seg.kind = restSegment
+		default:
+			return nil, fmt.Errorf("unknown placeholder type %q in {%s}", typ, part[1:len(part)-1])
+		}
+		t.segments = append(t.segments, seg)
+	}
+	for _, m := range placeholderRE.FindAllStringSubmatch(link.URL, -1) {
+		if !names[m[1]] {
+			return nil, fmt.Errorf("url uses undefined placeholder {%s}", m[1])
+		}
+	}
+	return t, nil
+}
+
+var placeholderRE = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// match returns the placeholder values if path matches t.
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	values := make(map[string]string)
+	for i, seg := range t.segments {
+		if i >= len(parts) {
+			return nil, false
+		}
+		if seg.kind == restSegment {
+			rest := strings.Join(parts[i:], "/")
+			if rest == "" {
+				return nil, false
+			}
+			values[seg.value] = rest
+			return values, true
+		}
+		if !seg.matches(parts[i]) {
+			return nil, false
+		}
+		if seg.kind != literalSegment {
+			values[seg.value] = parts[i]
+		}
+	}
+	if len(parts) != len(t.segments) {
+		return nil, false
+	}
+	return values, true
+}
+
+// expand returns a copy of link with the placeholder values
+// substituted into its URL.
+func (t *pathTemplate) expand(link Link, values map[string]string) Link {
+	query := strings.IndexByte(link.URL, '?')
+	var b strings.Builder
+	last := 0
+	for _, m := range placeholderRE.FindAllStringSubmatchIndex(link.URL, -1) {
+		b.WriteString(link.URL[last:m[0]])
+		inQuery := query >= 0 && m[0] > query
+		b.WriteString(escapeValue(values[link.URL[m[2]:m[3]]], inQuery))
+		last = m[1]
+	}
+	b.WriteString(link.URL[last:])
+	link.URL = b.String()
+	return link
+}
+
+// overlaps reports whether some request could match both t
+// and u.
+func (t *pathTemplate) overlaps(u *pathTemplate) bool {
+	return t.host == u.host && segmentsOverlap(t.segments, u.segments)
+}
+
+func segmentsOverlap(a, b []segment) bool {
+	switch {
+	case len(a) == 0 || len(b) == 0:
+		return len(a) == len(b)
+	case a[0].kind == restSegment || b[0].kind == restSegment:
+		return true
+	case a[0].kind == literalSegment && b[0].kind == literalSegment:
+		if a[0].value != b[0].value {
+			return false
+		}
+	case a[0].kind == literalSegment:
+		if !b[0].matches(a[0].value) {
+			return false
+		}
+	case b[0].kind == literalSegment:
+		if !a[0].matches(b[0].value) {
+			return false
+		}
+	}
+	// Any two placeholder types share some value (digits are
+	// valid slugs and every type accepts a plain segment).
+	return segmentsOverlap(a[1:], b[1:])
+}
+
+// escapeValue escapes a value taken from the request path for
+// use in the path or the query of a destination URL.
+func escapeValue(v string, inQuery bool) string {
+	if inQuery {
+		return url.QueryEscape(v)
+	}
+	return (&url.URL{Path: v}).EscapedPath()
+}
@@ -0,0 +1,210 @@
+package urlshort
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Deleting a link with SoftDelete, as Admin and the urlshort
+// command do, doesn't remove it from the store. Instead it
+// becomes a tombstone: the link with DeletedAt, DeletedBy and
+// an optional DeleteReason set, which link files show as
+//
+//     - path: /oncall
+//       url: https://wiki.example.com/oncall
+//       deleted_at: 2025-05-01T09:30:00Z
+//       deleted_by: alice
+//       delete_reason: moved to the incident tool
+//
+// Handler answers requests for a tombstone with a 410 Gone
+// page rather than passing them to the fallback, so people
+// following an old link learn that it was removed on purpose.
+// The page shows the reason, if any, but not who deleted it.
+//
+// Restore brings a tombstone back to life, and
+// PurgeTombstones, or a Purger running in the background,
+// deletes tombstones for good once they are old enough. Store
+// implementations only see ordinary Puts and Deletes.
+
+// ErrDeleted is returned by Resolve for a link that has been
+// soft deleted.
+var ErrDeleted = errors.New("urlshort: link deleted")
+
+// Deleted reports whether link is a tombstone.
+func (link Link) Deleted() bool {
+	return link.DeletedAt != nil
+}
+
+// SoftDelete turns the link stored under key into a tombstone
+// recording when it was deleted, by whom and why, and returns
+// the tombstone. It fails with ErrNotFound if store has no
+// link with that key, including when the link is already a
+// tombstone. Unless ctx already names an actor (see
+// ContextWithActor), by is also recorded as the author of the
+// change.
+func SoftDelete(ctx context.Context, store MutableStore, key, by, reason string) (Link, error) {
+	link, err := lookupKey(ctx, store, key)
+	if err != nil {
+		return Link{}, err
+	}
+	if link.Deleted() {
+		return Link{}, fmt.Errorf("%w: %s is already deleted", ErrNotFound, key)
+	}
+	now := time.Now().UTC()
+	link.DeletedAt, link.DeletedBy, link.DeleteReason = &now, by, reason
+	if ActorFromContext(ctx) == "" {
+		ctx = ContextWithActor(ctx, by)
+	}
+	if err := store.Put(ctx, link); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// Restore turns the tombstone stored under key back into a
+// working link and returns it. It fails with ErrNotFound if
+// there is no link with that key, and ErrConflict if the link
+// isn't deleted.
+func Restore(ctx context.Context, store MutableStore, key string) (Link, error) {
+	link, err := lookupKey(ctx, store, key)
+	if err != nil {
+		return Link{}, err
+	}
+	if !link.Deleted() {
+		return Link{}, fmt.Errorf("%w: %s is not deleted", ErrConflict, key)
+	}
+	link.DeletedAt, link.DeletedBy, link.DeleteReason = nil, "", ""
+	if err := store.Put(ctx, link); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// Tombstones returns the tombstones in store, which must also
+// be a Lister, sorted by key.
+func Tombstones(ctx context.Context, store Store) ([]Link, error) {
+	lister, ok := store.(Lister)
+	if !ok {
+		return nil, errors.New("urlshort: store can't list links")
+	}
+	links, err := lister.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var tombstones []Link
+	for _, link := range links {
+		if link.Deleted() {
+			tombstones = append(tombstones, link)
+		}
+	}
+	return tombstones, nil
+}
+
+// PurgeTombstones permanently deletes the tombstones in store,
+// which must also be a Lister, that were deleted before
+// cutoff, and returns them.
+func PurgeTombstones(ctx context.Context, store MutableStore, cutoff time.Time) ([]Link, error) {
+	tombstones, err := Tombstones(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	var purged []Link
+	for _, link := range tombstones {
+		if !link.DeletedAt.Before(cutoff) {
+			continue
+		}
+		if err := store.Delete(ctx, link.Key()); err != nil && !errors.Is(err, ErrNotFound) {
+			return purged, err
+		}
+		purged = append(purged, link)
+	}
+	return purged, nil
+}
+
+// A Purger periodically purges the tombstones of a store that
+// are older than MaxAge.
+type Purger struct {
+	Store  MutableStore
+	MaxAge time.Duration
+	// ErrorLog specifies an optional logger for purge errors
+	// and for the links purged. If nil, logging is done via
+	// the log package's standard logger.
+	ErrorLog *log.Logger
+}
+
+// Run purges old tombstones every interval until ctx is done,
+// so it is normally run in its own goroutine.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		purged, err := PurgeTombstones(ctx, p.Store, time.Now().Add(-p.MaxAge))
+		for _, link := range purged {
+			p.logf("purged %s, deleted at %s", link.Key(), link.DeletedAt.Format(time.RFC3339))
+		}
+		if err != nil {
+			p.logf("purging tombstones: %v", err)
+		}
+	}
+}
+
+func (p *Purger) logf(format string, args ...interface{}) {
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// lookupKey returns the link stored under key itself, as
+// opposed to a wildcard, template or rule matching it.
+func lookupKey(ctx context.Context, store Store, key string) (Link, error) {
+	link, err := store.Lookup(ctx, key)
+	if err != nil {
+		return Link{}, err
+	}
+	if link.Match != "" || link.Key() != key {
+		return Link{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return link, nil
+}
+
+var gonePage = template.Must(template.New("gone").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>410 Gone</title></head>
+<body>
+<h1>Gone</h1>
+{{if .Deleted}}<p>The link {{.Path}} was removed on {{.Date}}.</p>
+{{with .Reason}}<p>{{.}}</p>
+{{end}}{{else}}<p>The link {{.Path}} expired on {{.Date}}.</p>
+{{end}}</body>
+</html>
+`))
+
+// serveGone writes the 410 Gone page for a tombstone or an
+// expired link.
+func serveGone(w http.ResponseWriter, r *http.Request, link Link) {
+	data := struct {
+		Path, Date, Reason string
+		Deleted            bool
+	}{Path: r.URL.Path, Deleted: link.Deleted(), Reason: link.DeleteReason}
+	if link.Deleted() {
+		data.Date = link.DeletedAt.UTC().Format(time.DateOnly)
+	} else if link.ExpiresAt != nil {
+		data.Date = link.ExpiresAt.UTC().Format(time.DateOnly)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusGone)
+	gonePage.Execute(w, data)
+}
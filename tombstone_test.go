@@ -0,0 +1,173 @@
+package urlshort
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTombstones(t *testing.T) {
+	ctx := context.Background()
+	store := NewMapStore(map[string]string{"/a": "https://a.com", "/b": "https://b.com"})
+	handler := Handler(store, http.HandlerFunc(fallback))
+
+	tomb, err := SoftDelete(ctx, store, "/a", "alice", "moved to <the wiki>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tomb.Deleted() || tomb.DeletedBy != "alice" {
+		t.Errorf("Unexpected tombstone %v", tomb)
+	}
+
+	t.Run("it serves a 410 page for tombstones", func(t *testing.T) {
+		result := serve(handler, "/a")
+		assertStatus(t, result, http.StatusGone)
+		body, _ := io.ReadAll(result.Body)
+		if !strings.Contains(string(body), "moved to &lt;the wiki&gt;") {
+			t.Errorf("Expected the escaped reason in the page, got %s", body)
+		}
+		if strings.Contains(string(body), "alice") {
+			t.Error("Expected the page not to say who deleted the link")
+		}
+	})
+
+	t.Run("it refuses to delete twice or restore live links", func(t *testing.T) {
+		if _, err := SoftDelete(ctx, store, "/a", "bob", ""); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound deleting a tombstone, got %v", err)
+		}
+		if _, err := Restore(ctx, store, "/b"); !errors.Is(err, ErrConflict) {
+			t.Errorf("Expected ErrConflict restoring a live link, got %v", err)
+		}
+	})
+
+	t.Run("it restores tombstones", func(t *testing.T) {
+		if _, err := Restore(ctx, store, "/a"); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, serve(handler, "/a"), http.StatusFound)
+	})
+
+	t.Run("it purges old tombstones", func(t *testing.T) {
+		old := time.Now().Add(-48 * time.Hour)
+		store.Put(ctx, Link{Path: "/old", URL: "https://old.com", DeletedAt: &old})
+		if _, err := SoftDelete(ctx, store, "/b", "", ""); err != nil {
+			t.Fatal(err)
+		}
+		purged, err := PurgeTombstones(ctx, store, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(purged) != 1 || purged[0].Path != "/old" {
+			t.Errorf("Expected only /old to be purged, got %v", purged)
+		}
+		assertStatus(t, serve(handler, "/old"), http.StatusOK)
+		assertStatus(t, serve(handler, "/b"), http.StatusGone)
+	})
+}
+
+func TestAdminTombstones(t *testing.T) {
+	store := NewMapStore(map[string]string{"/a": "https://a.com"})
+	admin := NewAdmin(store)
+	admin.TrustProxyUser = true
+	do := func(method, target string) *http.Response {
+		t.Helper()
+		request := httptest.NewRequest(method, target, nil)
+		request.Header.Set("X-Forwarded-User", "carol")
+		response := httptest.NewRecorder()
+		admin.ServeHTTP(response, request)
+		return response.Result()
+	}
+
+	assertStatus(t, do("DELETE", "/api/links/a?reason=campaign+over"), http.StatusNoContent)
+	var tombstones []Link
+	json.NewDecoder(do("GET", "/api/tombstones").Body).Decode(&tombstones)
+	if len(tombstones) != 1 || tombstones[0].DeletedBy != "carol" || tombstones[0].DeleteReason != "campaign over" {
+		t.Errorf("Unexpected tombstones %v", tombstones)
+	}
+	var links []Link
+	json.NewDecoder(do("GET", "/api/links").Body).Decode(&links)
+	if len(links) != 0 {
+		t.Errorf("Expected tombstones to be left out of the links, got %v", links)
+	}
+
+	assertStatus(t, do("POST", "/api/restore/a"), http.StatusOK)
+	assertStatus(t, do("POST", "/api/restore/a"), http.StatusConflict)
+	assertStatus(t, do("GET", "/api/links/a"), http.StatusOK)
+
+	assertStatus(t, do("DELETE", "/api/links/a"), http.StatusNoContent)
+	assertStatus(t, do("POST", "/api/tombstones/purge?older_than=1h"), http.StatusOK)
+	assertStatus(t, do("GET", "/api/links/a"), http.StatusGone)
+	assertStatus(t, do("POST", "/api/tombstones/purge"), http.StatusOK)
+	assertStatus(t, do("GET", "/api/links/a"), http.StatusNotFound)
+
+	t.Run("it doesn't believe unauthenticated users", func(t *testing.T) {
+		admin := NewAdmin(NewMapStore(map[string]string{"/b": "https://b.com"}))
+		request := httptest.NewRequest("DELETE", "/api/links/b", nil)
+		request.Header.Set("X-Forwarded-User", "mallory")
+		request.SetBasicAuth("alice", "wrong")
+		admin.ServeHTTP(httptest.NewRecorder(), request)
+		tombstones, _ := Tombstones(context.Background(), admin.store)
+		if len(tombstones) != 1 || tombstones[0].DeletedBy != "anonymous" {
+			t.Errorf("Expected the link to be deleted by anonymous, got %v", tombstones)
+		}
+	})
+
+	t.Run("it creates links over tombstones", func(t *testing.T) {
+		store := NewMapStore(map[string]string{"/b": "https://b.com"})
+		SoftDelete(context.Background(), store, "/b", "carol", "")
+		request := httptest.NewRequest("POST", "/api/links", strings.NewReader(`{"path": "/b", "url": "https://b.org"}`))
+		response := httptest.NewRecorder()
+		NewAdmin(store).ServeHTTP(response, request)
+		assertStatus(t, response.Result(), http.StatusCreated)
+		if link, err := store.Lookup(context.Background(), "/b"); err != nil || link.Deleted() || link.URL != "https://b.org" {
+			t.Errorf("Expected the tombstone to be replaced, got %v, %v", link, err)
+		}
+	})
+}
+
+func TestTombstonesPersist(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	sqlite, err := OpenSQLiteStore(filepath.Join(dir, "links.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	bolt, err := OpenBoltStore(filepath.Join(dir, "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+	path := filepath.Join(dir, "links.csv")
+	writeFile(t, path, "path,url\n/a,https://a.com\n")
+	file, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]MutableStore{"sqlite": sqlite, "bolt": bolt, "file": file} {
+		if err := store.Put(ctx, Link{Path: "/a", URL: "https://a.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := SoftDelete(ctx, store, "/a", "dave", "typo, see /b"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		link, err := store.Lookup(ctx, "/a")
+		if err != nil || !link.Deleted() || link.DeletedBy != "dave" || link.DeleteReason != "typo, see /b" {
+			t.Errorf("%s: expected the tombstone to be stored, got %v, %v", name, link, err)
+		}
+	}
+	if err := file.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if link, _ := file.Lookup(ctx, "/a"); !link.Deleted() {
+		t.Errorf("Expected the tombstone to survive a reload of the CSV file, got %v", link)
+	}
+}
@@ -0,0 +1,70 @@
+package urlshort
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// TOML link files are an array of tables named links:
+//
+//     [[links]]
+//     path = "/a"
+//     url = "https://a.example.com"
+//
+//     [[links]]
+//     path = "/b"
+//     url = "https://b.example.com"
+//     status = 301
+
+func init() {
+	RegisterFormat(Format{
+		Name:       "toml",
+		Extensions: []string{".toml"},
+		Sniff: func(data []byte) bool {
+			return len(tomlTables(data)) > 0
+		},
+		Decode: parseTOML,
+		Encode: func(links []Link) ([]byte, error) {
+			var buf bytes.Buffer
+			err := toml.NewEncoder(&buf).Encode(tomlFile{Links: links})
+			return buf.Bytes(), err
+		},
+	})
+}
+
+type tomlFile struct {
+	Links []Link `toml:"links"`
+}
+
+func parseTOML(data []byte) ([]Entry, error) {
+	var file tomlFile
+	if _, err := toml.Decode(string(data), &file); err != nil {
+		return nil, err
+	}
+	lines := tomlTables(data)
+	entries := make([]Entry, len(file.Links))
+	for i, link := range file.Links {
+		entries[i].Link = link
+		if i < len(lines) {
+			entries[i].Pos = Pos{Line: lines[i], Column: 1}
+		}
+	}
+	return entries, nil
+}
+
+// tomlTables returns the line numbers of the [[links]] table
+// headers in data.
+func tomlTables(data []byte) []int {
+	var lines []int
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "[[") && strings.Trim(strings.Trim(line, "[]"), " ") == "links" {
+			lines = append(lines, n)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,300 @@
+package urlshort
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Pos is the position of an entry in a link file. YAML
+// entries have a line and column; JSON entries also have the
+// byte offset of the entry in the file. The zero Pos means
+// the position is unknown.
+type Pos struct {
+	Line, Column int
+	Offset       int64
+}
+
+func (p Pos) String() string {
+	switch {
+	case p.Line > 0 && p.Offset > 0:
+		return fmt.Sprintf("line %d, column %d (offset %d)", p.Line, p.Column, p.Offset)
+	case p.Line > 0:
+		return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+	case p.Offset > 0:
+		return fmt.Sprintf("offset %d", p.Offset)
+	}
+	return "unknown position"
+}
+
+// LinkError is a problem with a single entry of a link file.
+type LinkError struct {
+	Index int // of the entry in the file, starting at 0
+	Pos   Pos
+	Err   error
+}
+
+func (e *LinkError) Error() string {
+	if e.Pos == (Pos{}) {
+		return fmt.Sprintf("entry %d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("entry %d at %s: %v", e.Index, e.Pos, e.Err)
+}
+
+func (e *LinkError) Unwrap() error { return e.Err }
+
+// LinkErrors is every problem found while validating a set of
+// link definitions, in the order of the entries.
+type LinkErrors []*LinkError
+
+func (errs LinkErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d invalid links:", len(errs))
+	for _, err := range errs {
+		b.WriteString("\n\t")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the individual errors, so that errors.Is and
+// errors.As look at each of them.
+func (errs LinkErrors) Unwrap() []error {
+	wrapped := make([]error, len(errs))
+	for i, err := range errs {
+		wrapped[i] = err
+	}
+	return wrapped
+}
+
+// allowedSchemes are the destination URL schemes links may
+// redirect to. Anything else, javascript: and data: in
+// particular, is rejected.
+var allowedSchemes = map[string]bool{"http": true, "https": true}
+
+// validateLinks is validateEntries for links that weren't read
+// from a file, checking them together as one set.
+func validateLinks(links []Link, aliases *AliasPolicy) error {
+	entries := make([]Entry, len(links))
+	for i, link := range links {
+		entries[i].Link = link
+	}
+	return validateEntries(entries, aliases)
+}
+
+// validateEntries checks every entry and returns a LinkErrors
+// listing all of the problems found, or nil if there are
+// none. It checks for:
+//
+//   - missing or relative paths, and paths mixing
+//     placeholders with a wildcard
+//   - invalid or ambiguous templates and invalid regexes
+//   - duplicate paths (or patterns) for the same host
+//   - missing, malformed or relative URLs and URLs with a
+//     scheme other than http or https
+//   - invalid hosts, status codes and query policies
+//   - not_before times that aren't before expires_at
+//   - soft deleted match rules
+//   - paths not allowed by aliases, unless it is nil
+//   - links that redirect to themselves, directly or via
+//     other links in the same set (tombstones don't count)
+func validateEntries(entries []Entry, aliases *AliasPolicy) error {
+	var errs LinkErrors
+	seen := make(map[string]int)
+	var templates []*pathTemplate
+	var templateIndex []int
+	for i, e := range entries {
+		link := e.Link
+		fail := func(format string, args ...interface{}) {
+			errs = append(errs, &LinkError{Index: i, Pos: e.Pos, Err: fmt.Errorf(format, args...)})
+		}
+
+		// Only check the alias of a path that is otherwise valid,
+		// so that a bad path is reported once.
+		pathErrs := len(errs)
+		switch {
+		case link.Match != "" && link.Path != "":
+			fail("path and match can't both be set")
+		case link.Match != "":
+			if _, err := compileRule(link); err != nil {
+				fail("invalid match %q: %v", link.Match, err)
+			}
+		case link.Path == "":
+			fail("missing path")
+		case !strings.HasPrefix(link.Path, "/"):
+			fail("path %q must start with /", link.Path)
+		case isTemplate(link.Path) && isWildcard(link.Path):
+			fail("path %s mixes placeholders and a wildcard", link.Path)
+		case isTemplate(link.Path):
+			t, err := compileTemplate(link)
+			if err != nil {
+				fail("path %s: %v", link.Path, err)
+				break
+			}
+			for j, u := range templates {
+				if t.overlaps(u) {
+					fail("path %s is ambiguous with %s (entry %d)", link.Path, u.key, templateIndex[j])
+				}
+			}
+			templates = append(templates, t)
+			templateIndex = append(templateIndex, i)
+		}
+
+		if aliases != nil && link.Match == "" && link.Path != "" && len(errs) == pathErrs {
+			if err := aliases.Check(link.Path); err != nil {
+				fail("%v", err)
+			}
+		}
+
+		if key := duplicateKey(link); key != "" {
+			if j, ok := seen[key]; ok {
+				fail("duplicate of entry %d at %s", j, entries[j].Pos)
+			} else {
+				seen[key] = i
+			}
+		}
+
+		if strings.ContainsAny(link.Host, "/ ") {
+			fail("invalid host %q", link.Host)
+		}
+		if link.Status != 0 && !isRedirectStatus(link.Status) {
+			fail("invalid status %d", link.Status)
+		}
+		if !link.Query.valid() {
+			fail("invalid query policy %q", link.Query)
+		}
+		if link.Match != "" && link.Deleted() {
+			fail("match rules can't be soft deleted")
+		}
+		if link.NotBefore != nil && link.ExpiresAt != nil && !link.NotBefore.Before(*link.ExpiresAt) {
+			fail("not_before %s is not before expires_at %s",
+				link.NotBefore.Format(time.RFC3339), link.ExpiresAt.Format(time.RFC3339))
+		}
+		if err := checkURL(link.URL); err != nil {
+			fail("%v", err)
+		}
+	}
+	errs = append(errs, findLoops(entries)...)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Index < errs[j].Index })
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// foldEntries lowercases the paths of entries if aliases folds
+// case.
+func foldEntries(entries []Entry, aliases *AliasPolicy) {
+	for i := range entries {
+		entries[i].Path = aliases.fold(entries[i].Path)
+	}
+}
+
+// duplicateKey returns the key that two entries must not
+// share, or "" if the entry has neither a path nor a pattern.
+func duplicateKey(link Link) string {
+	switch {
+	case link.Match != "":
+		return canonicalHost(link.Host) + " match " + link.Match
+	case link.Path != "":
+		return link.Key()
+	}
+	return ""
+}
+
+// checkURL reports whether dest is an absolute URL with an
+// allowed scheme.
+func checkURL(dest string) error {
+	if dest == "" {
+		return fmt.Errorf("missing url")
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return fmt.Errorf("malformed url: %v", err)
+	}
+	if u.Scheme != "" && !allowedSchemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("url scheme %q is not allowed", u.Scheme)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("url %q is not absolute", dest)
+	}
+	return nil
+}
+
+// findLoops follows the exact links whose destinations are
+// served by the same set of links and reports every cycle,
+// including links that redirect straight back to themselves.
+// A destination is served by the set if its host and path
+// match a host-scoped link, or if its host appears on some
+// entry and its path matches a host-less link.
+func findLoops(entries []Entry) LinkErrors {
+	index := make(map[string]int)
+	hosts := make(map[string]bool)
+	for i, e := range entries {
+		if e.Match != "" || e.Path == "" || isTemplate(e.Path) || isWildcard(e.Path) || e.Deleted() {
+			continue
+		}
+		if _, ok := index[e.Key()]; !ok {
+			index[e.Key()] = i
+		}
+		if e.Host != "" {
+			hosts[canonicalHost(e.Host)] = true
+		}
+	}
+	next := func(i int) (int, bool) {
+		u, err := url.Parse(entries[i].URL)
+		if err != nil || u.Host == "" {
+			return 0, false
+		}
+		host := canonicalHost(u.Host)
+		if j, ok := index[host+u.Path]; ok {
+			return j, true
+		}
+		if j, ok := index[u.Path]; ok && hosts[host] {
+			return j, true
+		}
+		return 0, false
+	}
+
+	var errs LinkErrors
+	done := make(map[int]bool)
+	for start := range entries {
+		if j, ok := index[entries[start].Key()]; !ok || j != start || done[start] {
+			continue
+		}
+		var chain []int
+		onChain := make(map[int]int)
+		i, ok := start, true
+		for ok && !done[i] {
+			if at, seen := onChain[i]; seen {
+				errs = append(errs, loopError(entries, chain[at:]))
+				break
+			}
+			onChain[i] = len(chain)
+			chain = append(chain, i)
+			i, ok = next(i)
+		}
+		for _, i := range chain {
+			done[i] = true
+		}
+	}
+	return errs
+}
+
+func loopError(entries []Entry, cycle []int) *LinkError {
+	first := entries[cycle[0]]
+	if len(cycle) == 1 {
+		return &LinkError{Index: cycle[0], Pos: first.Pos, Err: fmt.Errorf("%s redirects to itself", first.Key())}
+	}
+	keys := make([]string, 0, len(cycle)+1)
+	for _, i := range cycle {
+		keys = append(keys, entries[i].Key())
+	}
+	keys = append(keys, first.Key())
+	return &LinkError{Index: cycle[0], Pos: first.Pos, Err: fmt.Errorf("redirect loop %s", strings.Join(keys, " -> "))}
+}
@@ -0,0 +1,135 @@
+package urlshort
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateYAML(t *testing.T) {
+	yml := `
+- path: /ok
+  url: https://example.com
+- path: nope
+  url: https://example.com
+- path: /ok
+  url: https://example.org
+- path: /js
+  url: javascript:alert(1)
+- path: /relative
+  url: ../somewhere
+- host: go.corp
+  path: /self
+  url: https://go.corp/self
+- host: go.corp
+  path: /a
+  url: https://go.corp/b
+- path: /b
+  url: https://GO.corp:443/a
+`
+	entries, err := parseYAML([]byte(yml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = validateEntries(entries, &DefaultAliasPolicy)
+
+	var errs LinkErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected LinkErrors, got %v", err)
+	}
+	want := []struct {
+		index int
+		line  int
+		text  string
+	}{
+		{1, 4, "must start with /"},
+		{2, 6, "duplicate of entry 0 at line 2"},
+		{3, 8, `scheme "javascript" is not allowed`},
+		{4, 10, "not absolute"},
+		{5, 12, "go.corp/self redirects to itself"},
+		{6, 15, "redirect loop go.corp/a -> /b -> go.corp/a"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %d errors, got %d:\n%v", len(want), len(errs), err)
+	}
+	for i, w := range want {
+		if errs[i].Index != w.index || errs[i].Pos.Line != w.line || !strings.Contains(errs[i].Error(), w.text) {
+			t.Errorf("Expected entry %d on line %d with %q, got %v", w.index, w.line, w.text, errs[i])
+		}
+	}
+}
+
+func TestValidateJSONOffsets(t *testing.T) {
+	data := `[
+  {"path": "/a", "url": "https://a.com"},
+  {"path": "/a", "url": "https://b.com"}
+]`
+	entries, err := parseJSON([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs LinkErrors
+	if !errors.As(validateEntries(entries, nil), &errs) || len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if pos := errs[0].Pos; pos.Offset != 46 || pos.Line != 3 || pos.Column != 3 {
+		t.Errorf("Expected offset 46 at line 3, column 3, got %s", pos)
+	}
+}
+
+func TestAliasPolicy(t *testing.T) {
+	yml := `
+- path: /api/links
+  url: https://example.com
+- path: /Healthz
+  url: https://example.com
+- path: /a%20b
+  url: https://example.com
+- path: /toolong
+  url: https://example.com
+- path: /docs/{page:slug}
+  url: https://example.com/{page}
+- path: /Go/*
+  url: https://golang.org
+- path: /*
+  url: https://example.com
+- path: /api/{id}/*
+  url: https://example.com
+`
+	policy := DefaultAliasPolicy
+	policy.MaxLength = 6
+	policy.FoldCase = true
+	_, err := YAMLHandler([]byte(yml), nil, WithAliasPolicy(policy))
+
+	var errs LinkErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected LinkErrors, got %v", err)
+	}
+	want := []struct {
+		index int
+		text  string
+	}{
+		{0, "/api/links is under the reserved path /api"},
+		{1, "/healthz is reserved"},
+		{2, "has characters outside"},
+		{3, "longer than 6 characters"},
+		{6, "/* covers the reserved path /"},
+		{7, "mixes placeholders and a wildcard"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %d errors, got %d:\n%v", len(want), len(errs), err)
+	}
+	for i, w := range want {
+		if errs[i].Index != w.index || !strings.Contains(errs[i].Error(), w.text) {
+			t.Errorf("Expected entry %d with %q, got %v", w.index, w.text, errs[i])
+		}
+	}
+
+	handler, err := YAMLHandler([]byte("- path: /Go/*\n  url: https://golang.org\n"), http.HandlerFunc(fallback), WithAliasPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertURL(t, serve(handler, "/GO/doc"), "https://golang.org/doc")
+	assertURL(t, serve(handler, "/go/doc"), "https://golang.org/doc")
+}
@@ -0,0 +1,73 @@
+package urlshort
+
+import (
+	"sort"
+	"strings"
+)
+
+// A wildcard link has a path ending in "/*", such as
+//
+//     - path: /gh/*
+//       url: https://github.com/*
+//
+// It matches any request path under the prefix, and the part
+// of the request path matched by the "*" is carried over to
+// the destination: it replaces the "*" in the URL if there is
+// one, or is appended to the URL otherwise. So /gh/gophercises
+// redirects to https://github.com/gophercises.
+//
+// Exact paths always win over wildcards, and when several
+// wildcards match, the one with the longest prefix wins.
+
+const wildcard = "*"
+
+// isWildcard reports whether path is a wildcard route.
+func isWildcard(path string) bool {
+	return strings.HasSuffix(path, "/"+wildcard)
+}
+
+// wildcardPrefix returns the prefix a wildcard path matches,
+// including its trailing slash.
+func wildcardPrefix(path string) string {
+	return strings.TrimSuffix(path, wildcard)
+}
+
+// matchWildcard returns the part of path matched by the
+// wildcard route pattern. The bare prefix without a trailing
+// slash (/gh for /gh/*) matches with an empty suffix.
+func matchWildcard(pattern, path string) (suffix string, ok bool) {
+	prefix := wildcardPrefix(pattern)
+	if path == strings.TrimSuffix(prefix, "/") {
+		return "", true
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+	return path[len(prefix):], true
+}
+
+// expandWildcard returns a copy of link with suffix carried
+// over to its destination URL.
+func expandWildcard(link Link, suffix string) Link {
+	suffix = escapeValue(suffix, false)
+	if strings.Contains(link.URL, wildcard) {
+		link.URL = strings.Replace(link.URL, wildcard, suffix, 1)
+		return link
+	}
+	if suffix != "" && !strings.HasSuffix(link.URL, "/") {
+		suffix = "/" + suffix
+	}
+	link.URL += suffix
+	return link
+}
+
+// sortWildcards orders wildcard paths so that the longest
+// prefix comes first.
+func sortWildcards(paths []string) {
+	sort.Slice(paths, func(i, j int) bool {
+		if len(paths[i]) != len(paths[j]) {
+			return len(paths[i]) > len(paths[j])
+		}
+		return paths[i] < paths[j]
+	})
+}